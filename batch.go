@@ -0,0 +1,96 @@
+package glslfilter
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// SetSizeSource makes the render size follow the size of the texture bound to bindingName. The
+// current ratio between the render size and the texture is kept whenever SetInputs or Process
+// replace the texture with an image of a different size. It must be called after Init, and
+// replaces the size rule of a definition with render.size.fromTexture.
+func (engine *Engine) SetSizeSource(bindingName string) error {
+	for _, stage := range engine.stages {
+		if texture, exists := stage.textures[bindingName]; exists {
+			engine.sizeRule = RenderSize{}
+			engine.sizeSource = bindingName
+			engine.sizeSourceScale.x = float64(engine.viewportSize.x) / float64(texture.size.X)
+			engine.sizeSourceScale.y = float64(engine.viewportSize.y) / float64(texture.size.Y)
+			return nil
+		}
+	}
+	return fmt.Errorf("no stage has a texture named %s", bindingName)
+}
+
+// Resize changes the render size, recreating the render targets of an initialized engine.
+func (engine *Engine) Resize(size image.Point) error {
+	if size.X <= 0 || size.Y <= 0 {
+		return fmt.Errorf("invalid render size %dx%d", size.X, size.Y)
+	}
+
+	previous := engine.viewportSize
+	engine.viewportSize.x, engine.viewportSize.y = size.X, size.Y
+	if engine.drawStage == nil {
+		return nil
+	}
+	if err := engine.SetStages(engine.stages); err != nil {
+		engine.viewportSize = previous
+		return err
+	}
+	return nil
+}
+
+// SetInputs replaces the data of stage textures by binding name, keeping the compiled stages. A
+// texture name shared by several stages updates all of them.
+func (engine *Engine) SetInputs(inputs map[string]image.Image) error {
+	renderSize := image.Pt(engine.viewportSize.x, engine.viewportSize.y)
+
+	for bindingName, data := range inputs {
+		found := false
+		for _, stage := range engine.stages {
+			if !stage.HasTexture(bindingName) {
+				continue
+			}
+			found = true
+			if err := stage.SetTexture(bindingName, data); err != nil {
+				return err
+			}
+		}
+		if !found {
+			return fmt.Errorf("no stage has a texture named %s", bindingName)
+		}
+
+		size := data.Bounds().Size()
+		switch bindingName {
+		case engine.sizeRule.FromTexture:
+			renderSize = engine.sizeRule.Resolve(size)
+		case engine.sizeSource:
+			renderSize.X = int(math.Round(float64(size.X) * engine.sizeSourceScale.x))
+			renderSize.Y = int(math.Round(float64(size.Y) * engine.sizeSourceScale.y))
+		}
+	}
+
+	if renderSize != image.Pt(engine.viewportSize.x, engine.viewportSize.y) {
+		return engine.Resize(renderSize)
+	}
+	return nil
+}
+
+// Process renders the pipeline with new texture data and reads back the result: an *image.RGBA
+// for RGBA8 targets, otherwise a *FloatImage. The render size only changes with the size of the
+// inputs for engines built from a definition with render.size.fromTexture, or after
+// SetSizeSource.
+func (engine *Engine) Process(inputs map[string]image.Image) (image.Image, error) {
+	if err := engine.SetInputs(inputs); err != nil {
+		return nil, err
+	}
+	if err := engine.Render(); err != nil {
+		return nil, err
+	}
+
+	if engine.targets[engine.finalTarget].format == RGBA8 {
+		return engine.GetLastRenderImage(), nil
+	}
+	return engine.GetLastRenderFloat(), nil
+}
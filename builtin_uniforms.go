@@ -0,0 +1,180 @@
+package glslfilter
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+	"time"
+)
+
+const kTimeUniformName = "time"
+const kTimeDeltaUniformName = "timeDelta"
+const kFrameUniformName = "frame"
+const kStageIndexUniformName = "stageIndex"
+const kMouseUniformName = "mouse"
+
+// kResolutionUniformSuffix names the uniform holding the size of a sampler's texture, e.g.
+// inputTextureResolution for inputTexture.
+const kResolutionUniformSuffix = "Resolution"
+
+// kEngineUniformNames are the uniforms the engine sets itself, besides the resolutions of samplers.
+var kEngineUniformNames = map[string]bool{
+	kViewportSizeBindingName: true,
+	kTimeUniformName:         true,
+	kTimeDeltaUniformName:    true,
+	kFrameUniformName:        true,
+	kStageIndexUniformName:   true,
+	kMouseUniformName:        true,
+}
+
+// kAnimatedUniformNames are the engine uniforms that can change between renders of the same
+// pipeline.
+var kAnimatedUniformNames = []string{kTimeUniformName, kTimeDeltaUniformName, kFrameUniformName, kMouseUniformName}
+
+// builtinUniforms holds the values of the engine's uniforms for a pass.
+type builtinUniforms struct {
+	outputResolution image.Point
+	time             float64
+	timeDelta        float64
+	frame            int
+	stageIndex       int
+	mouse            [2]float64
+}
+
+// SetClock sets the clock time and timeDelta are read from, which returns the time since the
+// animation started. Offline renders can use it to step through frames at a fixed rate. By
+// default, and when clock is nil, time starts at the first render.
+func (engine *Engine) SetClock(clock func() time.Duration) {
+	engine.clock = clock
+}
+
+// SetMouse sets the value of the mouse uniform, a position in pixels of the render size with the
+// origin at the bottom left like gl_FragCoord.
+func (engine *Engine) SetMouse(x, y float64) {
+	engine.mouse = [2]float64{x, y}
+}
+
+// Animated reports whether a stage uses time, timeDelta, frame or mouse, so that rendering again
+// can give a different result.
+func (engine *Engine) Animated() bool {
+	for _, stage := range engine.stages {
+		for _, name := range kAnimatedUniformNames {
+			if _, active := stage.activeUniforms[name]; active {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nextFrame returns the engine's uniforms for a render and advances the frame count.
+func (engine *Engine) nextFrame() (builtins builtinUniforms) {
+	if engine.clock == nil {
+		start := time.Now()
+		engine.clock = func() time.Duration {
+			return time.Since(start)
+		}
+	}
+
+	builtins.time = engine.clock().Seconds()
+	if engine.frame > 0 {
+		builtins.timeDelta = builtins.time - engine.lastFrameTime
+	}
+	builtins.frame = engine.frame
+	builtins.mouse = engine.mouse
+
+	engine.lastFrameTime = builtins.time
+	engine.frame++
+	return builtins
+}
+
+func (stage *FilterStage) setBuiltinUniforms(builtins builtinUniforms) error {
+	values := []struct {
+		name   string
+		values []float64
+	}{
+		{kViewportSizeBindingName, []float64{float64(builtins.outputResolution.X), float64(builtins.outputResolution.Y)}},
+		{kTimeUniformName, []float64{builtins.time}},
+		{kTimeDeltaUniformName, []float64{builtins.timeDelta}},
+		{kFrameUniformName, []float64{float64(builtins.frame)}},
+		{kStageIndexUniformName, []float64{float64(builtins.stageIndex)}},
+		{kMouseUniformName, builtins.mouse[:]},
+	}
+	for _, value := range values {
+		if err := stage.setBuiltinUniform(value.name, value.values...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setResolutionUniform sets the resolution uniform of the sampler bindingName to size.
+func (stage *FilterStage) setResolutionUniform(bindingName string, size image.Point) error {
+	return stage.setBuiltinUniform(bindingName+kResolutionUniformSuffix, float64(size.X), float64(size.Y))
+}
+
+// setBuiltinUniform sets an engine uniform if the shader uses it and the definition doesn't set it.
+// It can be declared with any numeric scalar type with as many components as values.
+func (stage *FilterStage) setBuiltinUniform(name string, values ...float64) (err error) {
+	shaderUniform, active := stage.activeUniforms[name]
+	if !active {
+		return nil
+	}
+	if _, defined := stage.uniforms[name]; defined {
+		return nil
+	}
+
+	uniformType := UniformType{ScalarType: shaderUniform.Type.ScalarType}
+	if len(values) > 1 {
+		uniformType.VectorSize = len(values)
+	}
+	if shaderUniform.Type != uniformType {
+		shape := "scalar"
+		if len(values) > 1 {
+			shape = fmt.Sprintf("vector with %d components", len(values))
+		}
+		return fmt.Errorf("uniform %s is set by the engine and must be a non-array %s of any numeric type, the shader declares %s", name, shape, shaderUniform.typeName())
+	}
+
+	if uniformType.ScalarType != Float && uniformType.ScalarType != Double {
+		// an int time counts whole seconds, and a uint mouse stops at the edge of the window
+		truncated := make([]float64, len(values))
+		for i, value := range values {
+			truncated[i] = math.Trunc(value)
+			if uniformType.ScalarType == Uint {
+				truncated[i] = math.Max(truncated[i], 0)
+			}
+		}
+		values = truncated
+	}
+
+	var normed interface{}
+	switch uniformType.ScalarType {
+	case Float:
+		normed, err = normToFloat(values)
+	case Int, Bool:
+		normed, err = normToInt(values)
+	case Uint:
+		normed, err = normToUint(values)
+	case Double:
+		normed, err = normToDouble(values)
+	}
+	if err != nil {
+		return err
+	}
+	return setUniform(shaderUniform.Location, uniformType, false, normed)
+}
+
+// isEngineUniform reports whether the engine sets a uniform of the stage, unless its definition
+// does.
+func (stage *FilterStage) isEngineUniform(name string) bool {
+	if kEngineUniformNames[name] {
+		return true
+	}
+	if !strings.HasSuffix(name, kResolutionUniformSuffix) {
+		return false
+	}
+	sampler, exists := stage.activeUniforms[strings.TrimSuffix(name, kResolutionUniformSuffix)]
+	return exists && sampler.Sampler
+}
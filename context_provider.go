@@ -0,0 +1,111 @@
+package glslfilter
+
+import (
+	"image"
+	"runtime"
+	"unsafe"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+// ContextCapabilities describes what a ContextProvider's context can do besides rendering to
+// framebuffer objects.
+type ContextCapabilities struct {
+	// Visible is set when SwapBuffers shows the default framebuffer in a window.
+	Visible bool
+	// FramebufferSize is the size of the default framebuffer, zero if the context has none.
+	FramebufferSize image.Point
+}
+
+// ContextProvider creates and owns the OpenGL context an Engine renders with. Providers that can
+// load GL functions themselves also implement ProcAddressLoader, otherwise the platform's default
+// loader is used.
+type ContextProvider interface {
+	Create() error
+	MakeCurrent() error
+	SwapBuffers() error
+	Capabilities() ContextCapabilities
+	Destroy()
+}
+
+// ProcAddressLoader is implemented by context providers that resolve GL functions through their own
+// window system API, e.g. eglGetProcAddress.
+type ProcAddressLoader interface {
+	GetProcAddress(name string) unsafe.Pointer
+}
+
+// CallerManagedContext is a ContextProvider for a context the caller has already created and made
+// current, such as the one of an application embedding the engine in its own window. Create,
+// MakeCurrent and Destroy do nothing.
+type CallerManagedContext struct {
+	ContextCapabilities
+	// SwapBuffersFunc presents the default framebuffer. It may be nil.
+	SwapBuffersFunc func() error
+}
+
+func (context *CallerManagedContext) Create() error {
+	return nil
+}
+
+func (context *CallerManagedContext) MakeCurrent() error {
+	return nil
+}
+
+func (context *CallerManagedContext) SwapBuffers() error {
+	if context.SwapBuffersFunc == nil {
+		return nil
+	}
+	return context.SwapBuffersFunc()
+}
+
+func (context *CallerManagedContext) Capabilities() ContextCapabilities {
+	return context.ContextCapabilities
+}
+
+func (context *CallerManagedContext) Destroy() {}
+
+// NewEngineWithContext creates the provider's context, makes it current and loads the GL functions
+// through it. The final result is drawn to the default framebuffer if the context is visible.
+//
+// GL contexts are current on a single OS thread, so the calling goroutine is locked to its thread
+// and the engine must only be used from it, unless an error is returned. Engine.Close destroys the
+// context.
+func NewEngineWithContext(context ContextProvider, viewportDimensions image.Rectangle, debug bool) (engine *Engine, err error) {
+	runtime.LockOSThread()
+	defer func() {
+		if err != nil {
+			runtime.UnlockOSThread()
+		}
+	}()
+
+	if err = context.Create(); err != nil {
+		return nil, err
+	}
+	if err = context.MakeCurrent(); err != nil {
+		context.Destroy()
+		return nil, err
+	}
+
+	if loader, ok := context.(ProcAddressLoader); ok {
+		err = gl.InitWithProcAddrFunc(loader.GetProcAddress)
+	} else {
+		err = gl.Init()
+	}
+	if err != nil {
+		context.Destroy()
+		return nil, err
+	}
+
+	engine = newEngine(viewportDimensions, debug, context.Capabilities().Visible)
+	engine.context = context
+	return engine, nil
+}
+
+// SwapBuffers presents the default framebuffer of the engine's context. It does nothing for
+// engines created without a ContextProvider.
+func (engine *Engine) SwapBuffers() error {
+	if engine.context == nil {
+		return nil
+	}
+	return engine.context.SwapBuffers()
+}
@@ -1,38 +1,116 @@
 package glslfilter
 
 import (
+	"errors"
+	"fmt"
+	"image"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/go-gl/gl/v3.3-core/gl"
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
 type TextureFilterType int32
 
+const (
+	FilterLinear  TextureFilterType = gl.LINEAR
+	FilterNearest TextureFilterType = gl.NEAREST
+)
+
 type TextureDefinition struct {
 	Path   string
 	Name   string
 	Filter TextureFilterType
+	// Data is used instead of loading Path when it's set.
+	Data image.Image `yaml:"-"`
 }
 
 type UniformDefinition struct {
 	Name  string
 	Type  UniformType
 	Value interface{}
+	// RowMajor means matrix values are written row by row instead of column by column.
+	RowMajor bool `yaml:"rowMajor"`
+}
+
+type StageDefinition struct {
+	FragmentShaderPath string `yaml:"fragmentShaderPath"`
+	// FragmentShaderSource is GLSL source used instead of loading FragmentShaderPath.
+	FragmentShaderSource string `yaml:"fragmentShaderSource"`
+	Output               string
+	Inputs               []StageInput
+	Size                 TargetSize `yaml:",inline"`
+	Format               TargetFormat
+	Textures             []TextureDefinition
+	Uniforms             []UniformDefinition
+	// Defines are added to the shader as #define directives after its #version.
+	Defines map[string]string
+	// Managed stages have a generated prelude declaring the built-ins, textures, inputs and
+	// uniforms, so their shaders start at their own declarations and functions.
+	Managed bool
 }
 
 type Definition struct {
+	// Params holds the values of the definition's params block after overrides, which ${}
+	// expressions elsewhere in the definition refer to.
+	Params map[string]string `yaml:"-"`
 	Render struct {
 		Width  int
 		Height int
+		Size   RenderSize
+		Format TargetFormat
+	}
+	Stages []StageDefinition
+	Output OutputDefinition
+	// IncludePaths are searched for the files named by #include directives in the shaders.
+	IncludePaths []string `yaml:"includePaths"`
+}
+
+// DefinitionError is a problem at a position in a definition. Line and Column are 1-based, and 0
+// when the position isn't known.
+type DefinitionError struct {
+	Line   int
+	Column int
+	Err    error
+}
+
+func (err *DefinitionError) Error() string {
+	if err.Line == 0 {
+		return err.Err.Error()
+	}
+	if err.Column == 0 {
+		return fmt.Sprintf("line %d: %v", err.Line, err.Err)
 	}
-	Stages []struct {
-		FragmentShaderPath string `yaml:"fragmentShaderPath"`
-		Textures           []TextureDefinition
-		Uniforms           []UniformDefinition
+	return fmt.Sprintf("line %d, column %d: %v", err.Line, err.Column, err.Err)
+}
+
+func (err *DefinitionError) Unwrap() error {
+	return err.Err
+}
+
+func newDefinitionError(node *yaml.Node, format string, args ...interface{}) *DefinitionError {
+	return &DefinitionError{Line: node.Line, Column: node.Column, Err: fmt.Errorf(format, args...)}
+}
+
+var kYAMLErrorLinePattern = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// newYAMLDefinitionError takes the line from a yaml package error message.
+func newYAMLDefinitionError(err error) *DefinitionError {
+	match := kYAMLErrorLinePattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return &DefinitionError{Err: err}
 	}
+	line, _ := strconv.Atoi(match[1])
+	return &DefinitionError{Line: line, Err: errors.New(match[2])}
 }
 
 func LoadDefinitionFromFile(reader io.Reader) (definition Definition, err error) {
@@ -44,28 +122,273 @@ func LoadDefinitionFromFile(reader io.Reader) (definition Definition, err error)
 }
 
 func LoadDefinition(definitionString []byte) (definition Definition, err error) {
-	err = yaml.Unmarshal(definitionString, &definition)
+	return LoadDefinitionWithOptions(definitionString, LoadOptions{})
+}
+
+// LoadOptions configures how ${} expressions in a definition are evaluated and where the files it
+// refers to are found.
+type LoadOptions struct {
+	// Params override the defaults in the definition's params block.
+	Params map[string]string
+	// ParamsDir is the directory relative Params are resolved against where the definition uses
+	// them as shader, texture or include paths, or through name.width and name.height, e.g. the
+	// working directory for params given on the command line. By default they are resolved
+	// against Dir like the definition's own paths. It's ignored with FS.
+	ParamsDir string
+	// Dir is the directory of the definition file. Relative shader, texture and include paths are
+	// resolved against it, and it's available to expressions as definitionDir. It defaults to the
+	// working directory, or the root of FS.
+	Dir string
+	// FS is the file system the definition's paths refer to. When it's nil they are OS paths.
+	FS fs.FS
+}
+
+// LoadDefinitionFromPath loads the definition file at definitionPath, resolving the paths in it
+// against the file's directory unless options.Dir is set.
+func LoadDefinitionFromPath(definitionPath string, options LoadOptions) (definition Definition, err error) {
+	definitionString, err := os.ReadFile(definitionPath)
+	if err != nil {
+		return definition, err
+	}
+	if options.Dir == "" {
+		options.Dir = filepath.Dir(definitionPath)
+	}
+	options.FS = nil
+	return LoadDefinitionWithOptions(definitionString, options)
+}
+
+// LoadDefinitionFromFS loads the definition file at definitionPath in fsys. Paths in the definition
+// are resolved against the file's directory in fsys unless options.Dir is set.
+func LoadDefinitionFromFS(fsys fs.FS, definitionPath string, options LoadOptions) (definition Definition, err error) {
+	definitionString, err := fs.ReadFile(fsys, definitionPath)
+	if err != nil {
+		return definition, err
+	}
+	if options.Dir == "" {
+		options.Dir = path.Dir(definitionPath)
+	}
+	options.FS = fsys
+	return LoadDefinitionWithOptions(definitionString, options)
+}
+
+func LoadDefinitionFromFileWithOptions(reader io.Reader, options LoadOptions) (definition Definition, err error) {
+	definitionString, err := ioutil.ReadAll(reader)
 	if err != nil {
 		return definition, err
 	}
+	return LoadDefinitionWithOptions(definitionString, options)
+}
+
+// LoadDefinitionWithOptions parses a definition, replacing ${} expressions in its values with
+// params from the params block or options, and resolving relative shader and texture paths
+// against options.Dir.
+func LoadDefinitionWithOptions(definitionString []byte, options LoadOptions) (definition Definition, err error) {
+	root, params, err := parseDefinition(definitionString, options)
+	if err != nil {
+		return definition, err
+	}
+	if err = root.Decode(&definition); err != nil {
+		return definition, err
+	}
+	definition.Params = params.values
+	definition.resolvePaths(params.files)
+	if err = definition.validateUniforms(); err != nil {
+		return definition, err
+	}
 
 	log.Println(definition)
 	return definition, nil
 }
 
-func (filterType *TextureFilterType) UnmarshalYAML(unmarshal func(interface{}) error) (err error) {
+// parseDefinition parses a definition into its root mapping node and evaluates its params, then
+// the ${} expressions in the rest of the node.
+func parseDefinition(definitionString []byte, options LoadOptions) (root *yaml.Node, params *definitionParams, err error) {
+	var document yaml.Node
+	if err = yaml.Unmarshal(definitionString, &document); err != nil {
+		return nil, nil, newYAMLDefinitionError(err)
+	}
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return nil, nil, &DefinitionError{Line: document.Line, Column: document.Column, Err: fmt.Errorf("a definition must be a mapping")}
+	}
+	root = document.Content[0]
+
+	files := definitionFiles{fsys: options.FS, dir: options.Dir}
+	if files.dir == "" {
+		files.dir = "."
+	}
+	// paths built from definitionDir must come out the same once they're resolved against it
+	definitionDir := "."
+	if files.fsys == nil {
+		if definitionDir, err = filepath.Abs(files.dir); err != nil {
+			return nil, nil, err
+		}
+	}
+	params = &definitionParams{
+		values:     map[string]string{kDefinitionDirParamName: definitionDir},
+		imageSizes: make(map[string]image.Point),
+		files:      files,
+		overrides:  options.Params,
+		pathNodes:  definitionPathNodes(root),
+	}
+	if options.ParamsDir != "" && files.fsys == nil {
+		if params.overridesDir, err = filepath.Abs(options.ParamsDir); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if defaults := mappingValue(root, "params"); defaults != nil && defaults.Tag != "!!null" {
+		if defaults.Kind != yaml.MappingNode {
+			return nil, nil, newDefinitionError(defaults, "params must be a mapping")
+		}
+		// defaults can refer to the params before them
+		for i := 0; i+1 < len(defaults.Content); i += 2 {
+			name, value := defaults.Content[i].Value, defaults.Content[i+1]
+			if override, overridden := options.Params[name]; overridden {
+				params.values[name] = override
+				continue
+			}
+			if value.Kind != yaml.ScalarNode {
+				return nil, nil, newDefinitionError(value, "param %s must be a single value", name)
+			}
+			if err = interpolateNode(value, params); err != nil {
+				return nil, nil, err
+			}
+			params.values[name] = value.Value
+		}
+	}
+	for name, value := range options.Params {
+		params.values[name] = value
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "params" {
+			continue
+		}
+		if err = interpolateNode(root.Content[i+1], params); err != nil {
+			return nil, nil, err
+		}
+	}
+	return root, params, nil
+}
+
+// validateUniforms checks that every uniform value matches its type, returning a *UniformError.
+func (definition Definition) validateUniforms() error {
+	for i, stageDefinition := range definition.Stages {
+		for _, uniformDefinition := range stageDefinition.Uniforms {
+			if _, err := normalizeUniformValue(uniformDefinition); err != nil {
+				uniformErr := err.(*UniformError)
+				uniformErr.Stage = i
+				return uniformErr
+			}
+		}
+	}
+	return nil
+}
+
+func (definition *Definition) resolvePaths(files definitionFiles) {
+	for i := range definition.IncludePaths {
+		definition.IncludePaths[i] = files.resolve(definition.IncludePaths[i])
+	}
+	for i := range definition.Stages {
+		stageDefinition := &definition.Stages[i]
+		stageDefinition.FragmentShaderPath = files.resolve(stageDefinition.FragmentShaderPath)
+		for j := range stageDefinition.Textures {
+			stageDefinition.Textures[j].Path = files.resolve(stageDefinition.Textures[j].Path)
+		}
+	}
+}
+
+// interpolateNode evaluates the ${} expressions in every string value of a parsed YAML node in
+// place. Values that are a single numeric expression become numbers. Keys are left alone.
+func interpolateNode(node *yaml.Node, params *definitionParams) error {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := interpolateNode(node.Content[i], params); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if err := interpolateNode(item, params); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if node.ShortTag() != "!!str" {
+			return nil
+		}
+		params.inPath = params.pathNodes[node]
+		value, err := params.interpolate(node.Value)
+		params.inPath = false
+		if err != nil {
+			return &DefinitionError{Line: node.Line, Column: node.Column, Err: err}
+		}
+		switch value := value.(type) {
+		case int:
+			node.Tag, node.Value = "!!int", strconv.Itoa(value)
+		case float64:
+			node.Tag, node.Value = "!!float", strconv.FormatFloat(value, 'f', -1, 64)
+		default:
+			node.Value = value.(string)
+		}
+	}
+	return nil
+}
+
+// definitionPathNodes returns the scalar nodes of a parsed definition holding the paths that are
+// resolved against its directory.
+func definitionPathNodes(root *yaml.Node) (pathNodes map[*yaml.Node]bool) {
+	pathNodes = make(map[*yaml.Node]bool)
+	add := func(node *yaml.Node) {
+		if node != nil && node.Kind == yaml.ScalarNode {
+			pathNodes[node] = true
+		}
+	}
+	if includePaths := mappingValue(root, "includePaths"); includePaths != nil && includePaths.Kind == yaml.SequenceNode {
+		for _, includePath := range includePaths.Content {
+			add(includePath)
+		}
+	}
+	if stages := mappingValue(root, "stages"); stages != nil && stages.Kind == yaml.SequenceNode {
+		for _, stage := range stages.Content {
+			add(mappingValue(stage, "fragmentShaderPath"))
+			if textures := mappingValue(stage, "textures"); textures != nil && textures.Kind == yaml.SequenceNode {
+				for _, texture := range textures.Content {
+					add(mappingValue(texture, "path"))
+				}
+			}
+		}
+	}
+	return pathNodes
+}
+
+// mappingValue returns the value of key in a mapping node, or nil if it isn't there.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func (filterType *TextureFilterType) UnmarshalYAML(value *yaml.Node) (err error) {
 	var rawString string
-	if err = unmarshal(&rawString); err != nil {
+	if err = value.Decode(&rawString); err != nil {
 		return err
 	}
 
-	switch rawString {
+	switch strings.ToUpper(rawString) {
 	case "NEAREST":
-		*filterType = gl.NEAREST
+		*filterType = FilterNearest
 	case "LINEAR":
-		fallthrough
+		*filterType = FilterLinear
 	default:
-		*filterType = TextureFilterType(gl.LINEAR)
+		return newDefinitionError(value, "invalid texture filter specified: \"%s\", options are (NEAREST|LINEAR)", rawString)
 	}
 
 	return nil
@@ -0,0 +1,69 @@
+package glslfilter
+
+import (
+	"image"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// definitionFiles locates the files a definition refers to. Relative paths are relative to dir,
+// which is a directory of fsys when it's set and of the OS file system otherwise.
+type definitionFiles struct {
+	fsys fs.FS
+	dir  string
+}
+
+func (files definitionFiles) resolve(name string) string {
+	if name == "" {
+		return name
+	}
+	if files.fsys != nil {
+		return path.Join(files.dir, name)
+	}
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(files.dir, name)
+}
+
+// beside returns files whose relative paths are relative to the directory containing name, which
+// has already been resolved.
+func (files definitionFiles) beside(name string) definitionFiles {
+	if files.fsys != nil {
+		return definitionFiles{fsys: files.fsys, dir: path.Dir(name)}
+	}
+	return definitionFiles{dir: filepath.Dir(name)}
+}
+
+// open opens a path that has already been resolved.
+func (files definitionFiles) open(name string) (io.ReadCloser, error) {
+	if files.fsys != nil {
+		return files.fsys.Open(name)
+	}
+	return os.Open(name)
+}
+
+func (files definitionFiles) loadFragmentShader(name string) (string, error) {
+	if files.fsys != nil {
+		return LoadFragmentShaderFS(files.fsys, name)
+	}
+	return LoadFragmentShader(name)
+}
+
+func (files definitionFiles) loadTextureData(name string) (image.Image, error) {
+	if files.fsys != nil {
+		return LoadTextureDataFS(files.fsys, name)
+	}
+	return LoadTextureData(name)
+}
+
+// stat checks a path that has already been resolved.
+func (files definitionFiles) stat(name string) (fs.FileInfo, error) {
+	if files.fsys != nil {
+		return fs.Stat(files.fsys, name)
+	}
+	return os.Stat(name)
+}
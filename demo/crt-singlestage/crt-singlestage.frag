@@ -1,17 +1,9 @@
 #version 330 core
-#extension GL_ARB_separate_shader_objects : enable
-#extension GL_ARB_explicit_uniform_location : enable
-#extension GL_ARB_shading_language_420pack : enable
+#include <glslfilter.glsl>
 
-layout(location = 0) in vec2 fragTexCoord;
-layout(location = 1) uniform ivec2 outputResolution;
 layout(binding = 0, location = 2) uniform sampler2D inputTexture;
 layout(binding = 1, location = 3) uniform sampler2D pixelTexture;
 
-layout(location = 0) out vec4 fragColor;
-
-const float M_PI = radians(180.0);
-
 vec2 barrelDistort(vec2 v) {
   const float kStretchRatio = 1.1;
 
@@ -19,7 +11,7 @@ vec2 barrelDistort(vec2 v) {
   float uva = atan(uv.x, uv.y);
   float uvd = sqrt(dot(uv, uv));
   //k = negative for pincushion, positive for barrel
-  float k = 0.1;
+  float k = BARREL_DISTORTION;
   uvd = uvd*(1.0 + k*uvd*uvd);
   return vec2(0.5) + vec2(sin(uva), cos(uva))*uvd;
 }
@@ -1,13 +1,3 @@
-#version 330 core
-#extension GL_ARB_separate_shader_objects : enable
-#extension GL_ARB_explicit_uniform_location : enable
-#extension GL_ARB_shading_language_420pack : enable
-
-layout(location = 0) in vec2 fragTexCoord;
-layout(binding = 0) uniform sampler2D previousResult;
-
-layout(location = 0) out vec4 fragColor;
-
 float M_PI = radians(180.0);
 
 void main() {
@@ -0,0 +1,14 @@
+// Declarations shared by the demo shaders. Include it after #version:
+//
+//   #version 330 core
+//   #include <glslfilter.glsl>
+#extension GL_ARB_separate_shader_objects : enable
+#extension GL_ARB_explicit_uniform_location : enable
+#extension GL_ARB_shading_language_420pack : enable
+
+layout(location = 0) in vec2 fragTexCoord;
+layout(location = 1) uniform ivec2 outputResolution;
+
+layout(location = 0) out vec4 fragColor;
+
+const float M_PI = radians(180.0);
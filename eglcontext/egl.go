@@ -0,0 +1,197 @@
+//go:build linux
+// +build linux
+
+// Package eglcontext creates headless OpenGL contexts with EGL, which needs neither a window nor
+// a display server. On Mesa the surfaceless platform is used, so it also works with llvmpipe on
+// machines without a GPU.
+package eglcontext
+
+/*
+#cgo LDFLAGS: -lEGL
+#include <stdlib.h>
+#include <EGL/egl.h>
+#include <EGL/eglext.h>
+
+#ifndef EGL_PLATFORM_SURFACELESS_MESA
+#define EGL_PLATFORM_SURFACELESS_MESA 0x31DD
+#endif
+
+static EGLDisplay getSurfacelessDisplay() {
+	PFNEGLGETPLATFORMDISPLAYEXTPROC getPlatformDisplay =
+		(PFNEGLGETPLATFORMDISPLAYEXTPROC)eglGetProcAddress("eglGetPlatformDisplayEXT");
+	if (getPlatformDisplay == NULL) {
+		return EGL_NO_DISPLAY;
+	}
+	return getPlatformDisplay(EGL_PLATFORM_SURFACELESS_MESA, EGL_DEFAULT_DISPLAY, NULL);
+}
+
+static EGLDisplay getDefaultDisplay() {
+	return eglGetDisplay(EGL_DEFAULT_DISPLAY);
+}
+
+static EGLContext createContext(EGLDisplay display, EGLConfig config) {
+	EGLint attributes[] = {
+		EGL_CONTEXT_MAJOR_VERSION, 3,
+		EGL_CONTEXT_MINOR_VERSION, 3,
+		EGL_CONTEXT_OPENGL_PROFILE_MASK, EGL_CONTEXT_OPENGL_CORE_PROFILE_BIT,
+		EGL_NONE,
+	};
+	return eglCreateContext(display, config, EGL_NO_CONTEXT, attributes);
+}
+
+static EGLSurface createPbufferSurface(EGLDisplay display, EGLConfig config, EGLint width, EGLint height) {
+	EGLint attributes[] = {
+		EGL_WIDTH, width,
+		EGL_HEIGHT, height,
+		EGL_NONE,
+	};
+	return eglCreatePbufferSurface(display, config, attributes);
+}
+
+static EGLBoolean chooseConfig(EGLDisplay display, EGLint surfaceType, EGLConfig *config) {
+	EGLint attributes[] = {
+		EGL_RENDERABLE_TYPE, EGL_OPENGL_BIT,
+		EGL_SURFACE_TYPE, surfaceType,
+		EGL_RED_SIZE, 8,
+		EGL_GREEN_SIZE, 8,
+		EGL_BLUE_SIZE, 8,
+		EGL_ALPHA_SIZE, 8,
+		EGL_NONE,
+	};
+	EGLint count = 0;
+	return eglChooseConfig(display, attributes, config, 1, &count) && count > 0;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"image"
+	"unsafe"
+
+	"github.com/smithjacobj/glslfilter"
+)
+
+// Context is an EGL context, optionally with a pbuffer surface to act as its default framebuffer.
+type Context struct {
+	width   int
+	height  int
+	display C.EGLDisplay
+	context C.EGLContext
+	surface C.EGLSurface
+}
+
+// New returns a provider for a surfaceless context. The engine only renders to framebuffer objects
+// when it isn't drawing to screen, so no default framebuffer is needed.
+func New() *Context {
+	return &Context{}
+}
+
+// NewPbuffer returns a provider for a context with a width x height pbuffer surface as its default
+// framebuffer.
+func NewPbuffer(width, height int) *Context {
+	return &Context{width: width, height: height}
+}
+
+func (context *Context) Create() (err error) {
+	pbuffer := context.width != 0 || context.height != 0
+	if pbuffer && (context.width < 0 || context.height < 0) {
+		return fmt.Errorf("egl: invalid pbuffer size %dx%d", context.width, context.height)
+	}
+
+	// prefer Mesa's surfaceless platform, which doesn't need a display server. cgo maps EGLDisplay
+	// to uintptr, hence the comparisons with 0.
+	context.display = C.getSurfacelessDisplay()
+	if context.display == 0 || C.eglInitialize(context.display, nil, nil) == C.EGL_FALSE {
+		context.display = C.getDefaultDisplay()
+		if context.display == 0 {
+			return fmt.Errorf("egl: no display available")
+		}
+		if C.eglInitialize(context.display, nil, nil) == C.EGL_FALSE {
+			context.display = 0
+			return eglError("eglInitialize")
+		}
+	}
+
+	if C.eglBindAPI(C.EGL_OPENGL_API) == C.EGL_FALSE {
+		err = eglError("eglBindAPI")
+		context.Destroy()
+		return err
+	}
+
+	var surfaceType C.EGLint
+	if pbuffer {
+		surfaceType = C.EGL_PBUFFER_BIT
+	}
+	var config C.EGLConfig
+	if C.chooseConfig(context.display, surfaceType, &config) == C.EGL_FALSE {
+		context.Destroy()
+		return fmt.Errorf("egl: no matching config")
+	}
+
+	context.context = C.createContext(context.display, config)
+	if context.context == nil {
+		err = eglError("eglCreateContext")
+		context.Destroy()
+		return err
+	}
+
+	if pbuffer {
+		context.surface = C.createPbufferSurface(context.display, config, C.EGLint(context.width), C.EGLint(context.height))
+		if context.surface == nil {
+			err = eglError("eglCreatePbufferSurface")
+			context.Destroy()
+			return err
+		}
+	}
+
+	return nil
+}
+
+func eglError(function string) error {
+	return fmt.Errorf("egl: %s failed (0x%x)", function, int(C.eglGetError()))
+}
+
+func (context *Context) MakeCurrent() error {
+	if C.eglMakeCurrent(context.display, context.surface, context.surface, context.context) == C.EGL_FALSE {
+		return eglError("eglMakeCurrent")
+	}
+	return nil
+}
+
+func (context *Context) SwapBuffers() error {
+	if context.surface == nil {
+		return nil
+	}
+	if C.eglSwapBuffers(context.display, context.surface) == C.EGL_FALSE {
+		return eglError("eglSwapBuffers")
+	}
+	return nil
+}
+
+func (context *Context) GetProcAddress(name string) unsafe.Pointer {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	return unsafe.Pointer(C.eglGetProcAddress(cName))
+}
+
+func (context *Context) Capabilities() glslfilter.ContextCapabilities {
+	return glslfilter.ContextCapabilities{FramebufferSize: image.Pt(context.width, context.height)}
+}
+
+func (context *Context) Destroy() {
+	if context.display == 0 {
+		return
+	}
+	C.eglMakeCurrent(context.display, nil, nil, nil)
+	if context.surface != nil {
+		C.eglDestroySurface(context.display, context.surface)
+		context.surface = nil
+	}
+	if context.context != nil {
+		C.eglDestroyContext(context.display, context.context)
+		context.context = nil
+	}
+	C.eglTerminate(context.display)
+	context.display = 0
+}
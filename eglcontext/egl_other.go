@@ -0,0 +1,44 @@
+//go:build !linux
+// +build !linux
+
+package eglcontext
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/smithjacobj/glslfilter"
+)
+
+// Context is only available on Linux.
+type Context struct{}
+
+func New() *Context {
+	return &Context{}
+}
+
+func NewPbuffer(width, height int) *Context {
+	return &Context{}
+}
+
+func (context *Context) Create() error {
+	return fmt.Errorf("egl: headless contexts are only supported on linux")
+}
+
+func (context *Context) MakeCurrent() error {
+	return fmt.Errorf("egl: headless contexts are only supported on linux")
+}
+
+func (context *Context) SwapBuffers() error {
+	return nil
+}
+
+func (context *Context) GetProcAddress(name string) unsafe.Pointer {
+	return nil
+}
+
+func (context *Context) Capabilities() glslfilter.ContextCapabilities {
+	return glslfilter.ContextCapabilities{}
+}
+
+func (context *Context) Destroy() {}
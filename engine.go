@@ -4,18 +4,26 @@ import (
 	"fmt"
 	"image"
 	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
 	_ "image/png"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"log"
 	"os"
+	"time"
 	"unsafe"
 
 	"github.com/go-gl/gl/v3.3-core/gl"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
 )
 
 const kGLLocationNotFound = -1
-const kViewportSizeBindingName = "outputResolution\x00"
-const kPreviousResultBindingName = "previousResult\x00"
+const kViewportSizeBindingName = "outputResolution"
+const kPreviousResultBindingName = "previousResult"
 
 var screenTriangleVertices = []float32{
 	-1, -3, 0, 0, 2,
@@ -70,30 +78,55 @@ void main() {
 	fragColor = texture(previousResult, fragTexCoord);	
 }`
 
-type interstageFBO struct {
+type renderTarget struct {
 	fboName     uint32
 	textureName uint32
+	size        image.Point
+	format      TargetFormat
 }
 
 type Engine struct {
-	debug          bool
-	drawToScreen   bool
-	viewportSize   struct{ x, y int }
-	fboVAO         uint32
-	screenVAO      uint32
-	stages         []*FilterStage
-	drawStage      *FilterStage
-	interstageFBOs [2]interstageFBO
+	context       ContextProvider
+	debug         bool
+	drawToScreen  bool
+	viewportSize  struct{ x, y int }
+	defaultFormat TargetFormat
+	fboVAO        uint32
+	fboVBO        uint32
+	screenVAO     uint32
+	screenVBO     uint32
+	stages        []*FilterStage
+	passes        []renderPass
+	drawStage     *FilterStage
+	targets       []renderTarget
+	finalTarget   int
+	// samplers holds a sampler object for each input filter, so that inputs reading the same
+	// target can filter it differently
+	samplers map[int32]uint32
+
+	sizeSource      string
+	sizeSourceScale struct{ x, y float64 }
+	// sizeRule is the render size rule of a definition with render.size.fromTexture, resolved
+	// again when the texture is replaced
+	sizeRule RenderSize
+
+	clock         func() time.Duration
+	frame         int
+	lastFrameTime float64
+	mouse         [2]float64
 }
 
 func NewEngine(viewportDimensions image.Rectangle, debug bool, drawToScreen bool) (engine *Engine, err error) {
-	engine = new(Engine)
-	engine.drawToScreen = drawToScreen
-
 	err = gl.Init()
 	if err != nil {
 		return nil, err
 	}
+	return newEngine(viewportDimensions, debug, drawToScreen), nil
+}
+
+func newEngine(viewportDimensions image.Rectangle, debug bool, drawToScreen bool) (engine *Engine) {
+	engine = new(Engine)
+	engine.drawToScreen = drawToScreen
 
 	engine.debug = debug
 	if debug {
@@ -105,25 +138,39 @@ func NewEngine(viewportDimensions image.Rectangle, debug bool, drawToScreen bool
 
 	engine.viewportSize.x = viewportDimensions.Dx()
 	engine.viewportSize.y = viewportDimensions.Dy()
+	engine.defaultFormat = RGBA8
 
-	return engine, nil
+	return engine
 }
 
+// SetDefaultFormat sets the render target format of stages that don't specify one. It must be
+// called before Init.
+func (engine *Engine) SetDefaultFormat(format TargetFormat) {
+	if format == 0 {
+		format = RGBA8
+	}
+	engine.defaultFormat = format
+}
+
+// Init creates the engine's own GL objects and sets up the render graph for stages. The engine
+// takes ownership of the stages and deletes them when they're replaced or the engine is closed.
 func (engine *Engine) Init(stages []*FilterStage) (err error) {
-	for i := range engine.interstageFBOs {
-		targetFBOName, targetFBOTextureName, err := createFramebufferTarget()
-		if err != nil {
+	if engine.drawStage == nil {
+		if engine.drawStage, err = NewFilterStage(lastResultToScreen, nil, nil); err != nil {
 			return err
 		}
-		engine.interstageFBOs[i] = interstageFBO{targetFBOName, targetFBOTextureName}
-		log.Printf("created FBO %d rendering to texture %d", targetFBOName, targetFBOTextureName)
+		engine.screenVAO, engine.screenVBO = createWindowBufferVAO(screenTriangleVertices)
+		engine.fboVAO, engine.fboVBO = createWindowBufferVAO(fboTriangleVertices)
+		engine.samplers = make(map[int32]uint32)
+		for _, filter := range []int32{gl.NEAREST, gl.LINEAR} {
+			sampler := createSamplerObject()
+			gl.SamplerParameteri(sampler, gl.TEXTURE_MIN_FILTER, filter)
+			gl.SamplerParameteri(sampler, gl.TEXTURE_MAG_FILTER, filter)
+			engine.samplers[filter] = sampler
+		}
 	}
 
-	engine.screenVAO = createWindowBufferVAO(screenTriangleVertices)
-	engine.fboVAO = createWindowBufferVAO(fboTriangleVertices)
-	engine.stages = stages
-
-	if engine.drawStage, err = NewFilterStage(lastResultToScreen, nil, nil); err != nil {
+	if err = engine.SetStages(stages); err != nil {
 		return err
 	}
 
@@ -132,22 +179,134 @@ func (engine *Engine) Init(stages []*FilterStage) (err error) {
 	return nil
 }
 
+// SetStages replaces the stages of an initialized engine and recreates its render targets. Previous
+// stages that aren't part of the new list are deleted. If an error is returned the previous stages
+// are kept.
+func (engine *Engine) SetStages(stages []*FilterStage) (err error) {
+	renderSize := image.Pt(engine.viewportSize.x, engine.viewportSize.y)
+	graph, err := buildRenderGraph(stages, renderSize, engine.defaultFormat, engine.drawStage)
+	if err != nil {
+		return err
+	}
+
+	for _, pass := range graph.passes {
+		if !hasEnoughTextureUnits(len(pass.stage.textures) + len(pass.inputs)) {
+			return fmt.Errorf("stage %d: more textures and inputs defined than available texture units", pass.index)
+		}
+	}
+
+	targets := make([]renderTarget, len(graph.targets))
+	for i, spec := range graph.targets {
+		targetFBOName, targetFBOTextureName, err := createFramebufferTarget(spec.size, spec.format)
+		if err != nil {
+			deleteRenderTargets(targets[:i])
+			return err
+		}
+		targets[i] = renderTarget{targetFBOName, targetFBOTextureName, spec.size, spec.format}
+		log.Printf("created %dx%d %s FBO %d rendering to texture %d", spec.size.X, spec.size.Y, spec.format, targetFBOName, targetFBOTextureName)
+	}
+
+	deleteRenderTargets(engine.targets)
+	kept := make(map[*FilterStage]bool)
+	for _, stage := range stages {
+		kept[stage] = true
+	}
+	for _, stage := range engine.stages {
+		if !kept[stage] {
+			stage.Delete()
+		}
+	}
+
+	engine.targets = targets
+	engine.stages = stages
+	engine.passes = graph.passes
+	engine.finalTarget = graph.finalTarget
+
+	return nil
+}
+
+// Stage returns one of the engine's stages, by its int index or its output name as a string.
+// Stages without an output are named stage0, stage1 and so on, as for inputs.
+func (engine *Engine) Stage(nameOrIndex interface{}) (*FilterStage, error) {
+	switch key := nameOrIndex.(type) {
+	case int:
+		if key < 0 || key >= len(engine.stages) {
+			return nil, fmt.Errorf("no stage %d, the engine has %d", key, len(engine.stages))
+		}
+		return engine.stages[key], nil
+	case string:
+		for i, stage := range engine.stages {
+			if stage.Output == key || (stage.Output == "" && fmt.Sprintf("stage%d", i) == key) {
+				return stage, nil
+			}
+		}
+		return nil, fmt.Errorf("no stage named %s", key)
+	}
+	return nil, fmt.Errorf("stages are found by int index or string name, got %T", nameOrIndex)
+}
+
+// Close deletes every GL object owned by the engine, including its stages, and destroys the
+// context if the engine was created with a ContextProvider. The engine can't be used afterwards.
+func (engine *Engine) Close() {
+	deleteRenderTargets(engine.targets)
+	for _, stage := range engine.stages {
+		stage.Delete()
+	}
+	if engine.drawStage != nil {
+		engine.drawStage.Delete()
+	}
+	deleteVertexArrayObject(engine.screenVAO)
+	deleteBufferObject(engine.screenVBO)
+	deleteVertexArrayObject(engine.fboVAO)
+	deleteBufferObject(engine.fboVBO)
+	for _, sampler := range engine.samplers {
+		deleteSamplerObject(sampler)
+	}
+
+	engine.targets = nil
+	engine.stages = nil
+	engine.passes = nil
+	engine.drawStage = nil
+	engine.samplers = nil
+	engine.screenVAO, engine.screenVBO, engine.fboVAO, engine.fboVBO = 0, 0, 0, 0
+
+	if engine.debug {
+		log.Printf("live GL objects after close: %+v", LiveGLObjects())
+	}
+
+	if engine.context != nil {
+		engine.context.Destroy()
+		engine.context = nil
+	}
+}
+
+func deleteRenderTargets(targets []renderTarget) {
+	for _, target := range targets {
+		deleteFramebufferObject(target.fboName)
+		deleteTextureObject(target.textureName)
+	}
+}
+
 func (engine *Engine) Render() error {
-	for i, stage := range engine.stages {
-		gl.UseProgram(stage.program)
+	builtins := engine.nextFrame()
+	for _, pass := range engine.passes {
+		stage := pass.stage
+		target := engine.targets[pass.target]
 
-		viewportSizeLocation := gl.GetUniformLocation(stage.program, gl.Str(kViewportSizeBindingName))
-		if viewportSizeLocation != kGLLocationNotFound {
-			gl.Uniform2i(viewportSizeLocation, int32(engine.viewportSize.x), int32(engine.viewportSize.y))
+		gl.UseProgram(stage.program)
+		builtins.outputResolution = target.size
+		builtins.stageIndex = pass.index
+		if err := stage.setBuiltinUniforms(builtins); err != nil {
+			return err
 		}
 
-		if i > 0 {
-			previousFBO := engine.interstageFBOs[(i-1)%2]
-			previousResultLocation := gl.GetUniformLocation(stage.program, gl.Str(kPreviousResultBindingName))
-			if previousResultLocation == kGLLocationNotFound {
-				return layoutNotFoundError("location", kPreviousResultBindingName)
-			} else {
-				gl.BindTextureUnit(uint32(previousResultLocation), previousFBO.textureName)
+		for _, input := range pass.inputs {
+			inputTarget := engine.targets[input.target]
+			if err := stage.bindTexture(input.bindingName, inputTarget.textureName, engine.samplers[input.filter]); err != nil {
+				return err
+			}
+			if err := stage.setResolutionUniform(input.bindingName, inputTarget.size); err != nil {
+				return err
 			}
 		}
 
@@ -158,9 +317,9 @@ func (engine *Engine) Render() error {
 			return err
 		}
 
-		targetFBO := engine.interstageFBOs[i%2]
-		gl.BindFramebuffer(gl.FRAMEBUFFER, targetFBO.fboName)
+		gl.BindFramebuffer(gl.FRAMEBUFFER, target.fboName)
 		gl.BindVertexArray(engine.fboVAO)
+		gl.Viewport(0, 0, int32(target.size.X), int32(target.size.Y))
 
 		gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
 		gl.DrawArrays(gl.TRIANGLES, 0, 3)
@@ -169,23 +328,25 @@ func (engine *Engine) Render() error {
 	// Why do we render to an FBO, then to the screen? So we can read the texture image from the
 	// last FBO for export.
 	if engine.drawToScreen {
-		gl.UseProgram(engine.drawStage.program)
+		viewportSize := image.Pt(engine.viewportSize.x, engine.viewportSize.y)
 
-		viewportSizeLocation := gl.GetUniformLocation(engine.drawStage.program, gl.Str(kViewportSizeBindingName))
-		if viewportSizeLocation != kGLLocationNotFound {
-			gl.Uniform2i(viewportSizeLocation, int32(engine.viewportSize.x), int32(engine.viewportSize.y))
+		gl.UseProgram(engine.drawStage.program)
+		builtins.outputResolution = viewportSize
+		if err := engine.drawStage.setBuiltinUniforms(builtins); err != nil {
+			return err
 		}
 
-		previousFBOtexture := engine.getFinalResultTexture()
-		previousResultLocation := gl.GetUniformLocation(engine.drawStage.program, gl.Str(kPreviousResultBindingName))
-		if previousResultLocation == kGLLocationNotFound {
-			return layoutNotFoundError("location", kPreviousResultBindingName)
-		} else {
-			gl.BindTextureUnit(uint32(previousResultLocation), previousFBOtexture)
+		finalTexture := engine.getFinalResultTexture()
+		if err := engine.drawStage.bindTexture(kPreviousResultBindingName, finalTexture, engine.samplers[gl.NEAREST]); err != nil {
+			return err
+		}
+		if err := engine.drawStage.setResolutionUniform(kPreviousResultBindingName, engine.targets[engine.finalTarget].size); err != nil {
+			return err
 		}
 
 		gl.BindFramebuffer(gl.FRAMEBUFFER, 0)
 		gl.BindVertexArray(engine.screenVAO)
+		gl.Viewport(0, 0, int32(viewportSize.X), int32(viewportSize.Y))
 
 		gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
 		gl.DrawArrays(gl.TRIANGLES, 0, 3)
@@ -202,17 +363,40 @@ func (engine *Engine) GetLastRenderImage() *image.RGBA {
 	return image
 }
 
+// GetLastRenderImage64 reads back the final result with 16 bits per channel, which keeps the
+// precision of float and high-bit-depth targets in [0, 1].
+func (engine *Engine) GetLastRenderImage64() *image.RGBA64 {
+	rect := image.Rect(0, 0, engine.viewportSize.x, engine.viewportSize.y)
+	image := image.NewRGBA64(rect)
+
+	// GL writes native-endian shorts, image.RGBA64 stores them big-endian
+	pixels := make([]uint16, len(image.Pix)/2)
+	gl.GetTextureImage(engine.getFinalResultTexture(), 0, gl.RGBA, gl.UNSIGNED_SHORT, int32(len(pixels)*2), gl.Ptr(&pixels[0]))
+	for i, v := range pixels {
+		image.Pix[i*2] = uint8(v >> 8)
+		image.Pix[i*2+1] = uint8(v)
+	}
+	return image
+}
+
+// GetLastRenderFloat reads back the final result unclamped, for float targets.
+func (engine *Engine) GetLastRenderFloat() *FloatImage {
+	rect := image.Rect(0, 0, engine.viewportSize.x, engine.viewportSize.y)
+	image := NewFloatImage(rect)
+
+	gl.GetTextureImage(engine.getFinalResultTexture(), 0, gl.RGBA, gl.FLOAT, int32(len(image.Pix)*4), gl.Ptr(&image.Pix[0]))
+	return image
+}
+
 func (engine *Engine) getFinalResultTexture() (texName uint32) {
-	return engine.interstageFBOs[(len(engine.stages)-1)%2].textureName
+	return engine.targets[engine.finalTarget].textureName
 }
 
-func createWindowBufferVAO(vertices []float32) (name uint32) {
-	var vbo uint32
-	gl.CreateBuffers(1, &vbo)
+func createWindowBufferVAO(vertices []float32) (vao, vbo uint32) {
+	vbo = createBufferObject()
 	gl.NamedBufferStorage(vbo, len(vertices)*int(unsafe.Sizeof(float32(0))), gl.Ptr(vertices), gl.MAP_READ_BIT)
 
-	var vao uint32
-	gl.CreateVertexArrays(1, &vao)
+	vao = createVertexArrayObject()
 	gl.EnableVertexArrayAttrib(vao, vertexPositionLocation)
 	gl.VertexArrayVertexBuffer(vao, 0, vbo, vertexPositionOffset, int32(vertexStride))
 	gl.VertexArrayAttribBinding(vao, vertexPositionLocation, 0)
@@ -223,16 +407,13 @@ func createWindowBufferVAO(vertices []float32) (name uint32) {
 	gl.VertexArrayAttribBinding(vao, vertexUVLocation, 0)
 	gl.VertexArrayAttribFormat(vao, vertexUVLocation, vertexUVSize, gl.FLOAT, false, uint32(vertexUVOffset))
 
-	return vao
+	return vao, vbo
 }
 
-func createFramebufferTarget() (fboName, texName uint32, err error) {
-	var viewBoundsVector [4]int32
-	gl.GetIntegerv(gl.VIEWPORT, &viewBoundsVector[0])
-	viewBounds := image.Rect(int(viewBoundsVector[0]), int(viewBoundsVector[1]), int(viewBoundsVector[2]), int(viewBoundsVector[3]))
-	gl.CreateFramebuffers(1, &fboName)
-	gl.CreateTextures(gl.TEXTURE_2D, 1, &texName)
-	gl.TextureStorage2D(texName, 1, gl.RGBA8, int32(viewBounds.Dx()), int32(viewBounds.Dy()))
+func createFramebufferTarget(size image.Point, format TargetFormat) (fboName, texName uint32, err error) {
+	fboName = createFramebufferObject()
+	texName = createTextureObject()
+	gl.TextureStorage2D(texName, 1, uint32(format), int32(size.X), int32(size.Y))
 	gl.TextureParameteri(texName, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
 	gl.TextureParameteri(texName, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
 	gl.NamedFramebufferTexture(fboName, gl.COLOR_ATTACHMENT0, texName, 0)
@@ -240,6 +421,8 @@ func createFramebufferTarget() (fboName, texName uint32, err error) {
 
 	status := gl.CheckNamedFramebufferStatus(fboName, gl.FRAMEBUFFER)
 	if status != gl.FRAMEBUFFER_COMPLETE {
+		deleteFramebufferObject(fboName)
+		deleteTextureObject(texName)
 		return 0, 0, fmt.Errorf("error creating framebuffer: %d", status)
 	}
 
@@ -252,6 +435,22 @@ func hasEnoughTextureUnits(n int) bool {
 	return n <= int(availableCount)
 }
 
+// hasDoublePrecision reports whether double uniforms are available.
+func hasDoublePrecision() bool {
+	var majorVersion, extensionCount int32
+	gl.GetIntegerv(gl.MAJOR_VERSION, &majorVersion)
+	if majorVersion >= 4 {
+		return true
+	}
+	gl.GetIntegerv(gl.NUM_EXTENSIONS, &extensionCount)
+	for i := int32(0); i < extensionCount; i++ {
+		if gl.GoStr(gl.GetStringi(gl.EXTENSIONS, uint32(i))) == "GL_ARB_gpu_shader_fp64" {
+			return true
+		}
+	}
+	return false
+}
+
 func LoadFragmentShader(fragmentShaderPath string) (string, error) {
 	fragmentShaderFile, err := os.Open(fragmentShaderPath)
 	if err != nil {
@@ -266,24 +465,75 @@ func LoadFragmentShader(fragmentShaderPath string) (string, error) {
 	return string(fragmentShaderSource), nil
 }
 
-func LoadTextureData(path string) (texture *image.RGBA, err error) {
+// LoadTextureData decodes an image file for use as a texture. 16-bit sources are returned as
+// *image.RGBA64 and EXR/HDR sources as *FloatImage so they keep their precision on upload,
+// everything else as *image.RGBA.
+func LoadTextureData(path string) (texture image.Image, err error) {
 	log.Printf("loading texture: %s\n", path)
 	imageFile, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer imageFile.Close()
 
-	imageData, _, err := image.Decode(imageFile)
+	return decodeTextureData(imageFile)
+}
+
+// LoadFragmentShaderFS is LoadFragmentShader for a file in fsys, e.g. an embed.FS or a zip.Reader.
+func LoadFragmentShaderFS(fsys fs.FS, fragmentShaderPath string) (string, error) {
+	fragmentShaderSource, err := fs.ReadFile(fsys, fragmentShaderPath)
+	if err != nil {
+		return "", err
+	}
+
+	return string(fragmentShaderSource), nil
+}
+
+// LoadTextureDataFS is LoadTextureData for a file in fsys.
+func LoadTextureDataFS(fsys fs.FS, path string) (texture image.Image, err error) {
+	log.Printf("loading texture: %s\n", path)
+	imageFile, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer imageFile.Close()
+
+	return decodeTextureData(imageFile)
+}
+
+func decodeTextureData(reader io.Reader) (texture image.Image, err error) {
+	imageData, _, err := image.Decode(reader)
 	if err != nil {
 		return nil, err
 	}
 
-	imageRGBA := image.NewRGBA(imageData.Bounds())
-	// we don't support cropped images (where the buffer size is larger than the image)
-	if imageRGBA.Stride != imageRGBA.Rect.Size().X*4 {
-		return nil, fmt.Errorf("unsupported stride: %d", imageRGBA.Rect.Size().X*4)
+	return normalizeTextureData(imageData), nil
+}
+
+// normalizeTextureData converts an image to a tightly packed type that can be uploaded directly:
+// *image.RGBA, *image.RGBA64 for 16-bit sources or *FloatImage.
+func normalizeTextureData(imageData image.Image) image.Image {
+	bounds := imageData.Bounds()
+	packedBounds := image.Rect(0, 0, bounds.Dx(), bounds.Dy())
+
+	switch imageData := imageData.(type) {
+	case *FloatImage:
+		if imageData.Stride == bounds.Dx()*4 {
+			return imageData
+		}
+		imageFloat := NewFloatImage(packedBounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			start := imageData.PixOffset(bounds.Min.X, y)
+			copy(imageFloat.Pix[imageFloat.PixOffset(0, y-bounds.Min.Y):], imageData.Pix[start:start+bounds.Dx()*4])
+		}
+		return imageFloat
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		imageRGBA64 := image.NewRGBA64(packedBounds)
+		draw.Draw(imageRGBA64, packedBounds, imageData, bounds.Min, draw.Src)
+		return imageRGBA64
 	}
-	draw.Draw(imageRGBA, imageRGBA.Bounds(), imageData, image.Point{0, 0}, draw.Src)
 
-	return imageRGBA, nil
+	imageRGBA := image.NewRGBA(packedBounds)
+	draw.Draw(imageRGBA, packedBounds, imageData, bounds.Min, draw.Src)
+	return imageRGBA
 }
@@ -0,0 +1,574 @@
+package glslfilter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+const kEXRMagic = "\x76\x2f\x31\x01"
+
+const (
+	kEXRVersionMask   = 0xff
+	kEXRTiledFlag     = 0x200
+	kEXRNonImageFlag  = 0x800
+	kEXRMultipartFlag = 0x1000
+)
+
+type EXRCompression uint8
+
+const (
+	EXRNoCompression   EXRCompression = 0
+	EXRRLECompression  EXRCompression = 1
+	EXRZIPSCompression EXRCompression = 2
+	EXRZIPCompression  EXRCompression = 3
+	EXRPIZCompression  EXRCompression = 4
+)
+
+// EXROptions are the parameters of EncodeEXR. Samples are written as half floats unless Float is
+// set.
+type EXROptions struct {
+	Compression EXRCompression
+	Float       bool
+}
+
+type exrPixelType int32
+
+const (
+	exrUint  exrPixelType = 0
+	exrHalf  exrPixelType = 1
+	exrFloat exrPixelType = 2
+)
+
+func (pixelType exrPixelType) size() int {
+	if pixelType == exrHalf {
+		return 2
+	}
+	return 4
+}
+
+type exrChannel struct {
+	name      string
+	pixelType exrPixelType
+	xSampling int32
+	ySampling int32
+}
+
+type exrHeader struct {
+	channels    []exrChannel
+	compression EXRCompression
+	dataWindow  image.Rectangle
+}
+
+func init() {
+	image.RegisterFormat("exr", kEXRMagic, DecodeEXR, DecodeEXRConfig)
+}
+
+func (compression EXRCompression) linesPerChunk() int {
+	switch compression {
+	case EXRZIPCompression:
+		return 16
+	case EXRPIZCompression:
+		return 32
+	}
+	return 1
+}
+
+// maxExpansion is the most a chunk can grow by when it's decompressed: 128 bytes from a 2-byte
+// RLE run, deflate's limit of 1032 to 1, and for PIZ 255 halfs from a 9-bit Huffman run.
+func (compression EXRCompression) maxExpansion() int {
+	switch compression {
+	case EXRRLECompression:
+		return 64
+	case EXRZIPSCompression, EXRZIPCompression:
+		return 1032
+	case EXRPIZCompression:
+		return 454
+	}
+	return 1
+}
+
+func (header *exrHeader) bytesPerLine() (n int) {
+	for _, channel := range header.channels {
+		n += header.dataWindow.Dx() * channel.pixelType.size()
+	}
+	return n
+}
+
+// DecodeEXR decodes a single-part scanline OpenEXR image into a *FloatImage. R, G, B and A
+// channels are read, or Y for luminance images; other channels and layers are ignored.
+func DecodeEXR(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	header, offset, err := readEXRHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	width := header.dataWindow.Dx()
+	height := header.dataWindow.Dy()
+	linesPerChunk := header.compression.linesPerChunk()
+	chunkCount := (height + linesPerChunk - 1) / linesPerChunk
+	if offset+chunkCount*8 > len(data) {
+		return nil, fmt.Errorf("exr: truncated offset table")
+	}
+	bytesPerLine := header.bytesPerLine()
+
+	// rows are allocated as their chunks are decoded, so that a small file declaring a large
+	// dataWindow can't allocate more than the pixel data it holds
+	img := &FloatImage{Stride: 4 * width, Rect: image.Rect(0, 0, width, height)}
+	if header.compression == EXRNoCompression {
+		if chunkCount*8+height*bytesPerLine > len(data)-offset-chunkCount*8 {
+			return nil, fmt.Errorf("exr: truncated pixel data")
+		}
+		img.Pix = make([]float32, 0, img.Stride*height)
+	}
+
+	components := make([]int, len(header.channels))
+	hasAlpha := false
+	for i, channel := range header.channels {
+		switch channel.name {
+		case "R":
+			components[i] = 0
+		case "G":
+			components[i] = 1
+		case "B":
+			components[i] = 2
+		case "A":
+			components[i] = 3
+			hasAlpha = true
+		case "Y":
+			components[i] = -2
+		default:
+			components[i] = -1
+		}
+	}
+	for i := 0; i < chunkCount; i++ {
+		rawOffset := binary.LittleEndian.Uint64(data[offset+i*8:])
+		if rawOffset > uint64(len(data)-8) {
+			return nil, fmt.Errorf("exr: invalid chunk offset %d", rawOffset)
+		}
+		chunkOffset := int(rawOffset)
+		y := int(int32(binary.LittleEndian.Uint32(data[chunkOffset:]))) - header.dataWindow.Min.Y
+		packedSize := int(int32(binary.LittleEndian.Uint32(data[chunkOffset+4:])))
+		// the offset table is in increasing y order, whatever order the chunks are stored in
+		if y != i*linesPerChunk || packedSize < 0 || chunkOffset+8+packedSize > len(data) {
+			return nil, fmt.Errorf("exr: invalid chunk at offset %d", chunkOffset)
+		}
+		packed := data[chunkOffset+8 : chunkOffset+8+packedSize]
+
+		lines := linesPerChunk
+		if y+lines > height {
+			lines = height - y
+		}
+
+		unpackedSize := lines * bytesPerLine
+		unpacked := packed
+		if packedSize < unpackedSize {
+			if unpackedSize > packedSize*header.compression.maxExpansion() {
+				return nil, fmt.Errorf("exr: chunk at line %d is too small for %d bytes of pixels", y, unpackedSize)
+			}
+			if unpacked, err = decompressEXRChunk(&header, packed, unpackedSize, lines); err != nil {
+				return nil, err
+			}
+		}
+		if len(unpacked) != unpackedSize {
+			return nil, fmt.Errorf("exr: chunk at line %d has %d bytes, expected %d", y, len(unpacked), unpackedSize)
+		}
+
+		start := len(img.Pix)
+		img.Pix = append(img.Pix, make([]float32, lines*img.Stride)...)
+		if !hasAlpha {
+			for j := start + 3; j < len(img.Pix); j += 4 {
+				img.Pix[j] = 1
+			}
+		}
+
+		pos := 0
+		for line := y; line < y+lines; line++ {
+			for c, channel := range header.channels {
+				for x := 0; x < width; x++ {
+					var v float32
+					switch channel.pixelType {
+					case exrHalf:
+						v = halfToFloat32(binary.LittleEndian.Uint16(unpacked[pos:]))
+					case exrFloat:
+						v = math.Float32frombits(binary.LittleEndian.Uint32(unpacked[pos:]))
+					case exrUint:
+						v = float32(binary.LittleEndian.Uint32(unpacked[pos:]))
+					}
+					pos += channel.pixelType.size()
+
+					i := img.PixOffset(x, line)
+					switch components[c] {
+					case -1:
+					case -2:
+						img.Pix[i], img.Pix[i+1], img.Pix[i+2] = v, v, v
+					default:
+						img.Pix[i+components[c]] = v
+					}
+				}
+			}
+		}
+	}
+
+	return img, nil
+}
+
+func DecodeEXRConfig(r io.Reader) (image.Config, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	header, _, err := readEXRHeader(data)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{
+		ColorModel: color.RGBA64Model,
+		Width:      header.dataWindow.Dx(),
+		Height:     header.dataWindow.Dy(),
+	}, nil
+}
+
+func readEXRHeader(data []byte) (header exrHeader, offset int, err error) {
+	if len(data) < 8 || string(data[:4]) != kEXRMagic {
+		return header, 0, fmt.Errorf("exr: not an OpenEXR file")
+	}
+	version := binary.LittleEndian.Uint32(data[4:])
+	if version&kEXRVersionMask != 2 {
+		return header, 0, fmt.Errorf("exr: unsupported version %d", version&kEXRVersionMask)
+	}
+	if version&(kEXRTiledFlag|kEXRNonImageFlag|kEXRMultipartFlag) != 0 {
+		return header, 0, fmt.Errorf("exr: tiled, deep and multi-part images are not supported")
+	}
+
+	readString := func() (string, error) {
+		end := bytes.IndexByte(data[offset:], 0)
+		if end < 0 {
+			return "", fmt.Errorf("exr: truncated header")
+		}
+		s := string(data[offset : offset+end])
+		offset += end + 1
+		return s, nil
+	}
+
+	offset = 8
+	hasChannels, hasDataWindow := false, false
+	for {
+		name, err := readString()
+		if err != nil {
+			return header, 0, err
+		}
+		if name == "" {
+			break
+		}
+		if _, err = readString(); err != nil {
+			return header, 0, err
+		}
+		if offset+4 > len(data) {
+			return header, 0, fmt.Errorf("exr: truncated header")
+		}
+		size := int(int32(binary.LittleEndian.Uint32(data[offset:])))
+		offset += 4
+		if size < 0 || offset+size > len(data) {
+			return header, 0, fmt.Errorf("exr: truncated attribute %s", name)
+		}
+		value := data[offset : offset+size]
+		offset += size
+
+		switch name {
+		case "channels":
+			if header.channels, err = readEXRChannels(value); err != nil {
+				return header, 0, err
+			}
+			hasChannels = true
+		case "compression":
+			if size != 1 {
+				return header, 0, fmt.Errorf("exr: invalid compression attribute")
+			}
+			header.compression = EXRCompression(value[0])
+			if header.compression > EXRPIZCompression {
+				return header, 0, fmt.Errorf("exr: unsupported compression %d, only none, RLE, ZIPS, ZIP and PIZ are supported", header.compression)
+			}
+		case "dataWindow":
+			if size != 16 {
+				return header, 0, fmt.Errorf("exr: invalid dataWindow attribute")
+			}
+			header.dataWindow = image.Rect(
+				int(int32(binary.LittleEndian.Uint32(value[0:]))),
+				int(int32(binary.LittleEndian.Uint32(value[4:]))),
+				int(int32(binary.LittleEndian.Uint32(value[8:])))+1,
+				int(int32(binary.LittleEndian.Uint32(value[12:])))+1,
+			)
+			hasDataWindow = true
+		}
+	}
+
+	if !hasChannels || !hasDataWindow {
+		return header, 0, fmt.Errorf("exr: missing channels or dataWindow attribute")
+	}
+	if header.dataWindow.Empty() {
+		return header, 0, fmt.Errorf("exr: empty dataWindow")
+	}
+	width, height := header.dataWindow.Dx(), header.dataWindow.Dy()
+	if width > kMaxDecodedPixels || height > kMaxDecodedPixels || width*height > kMaxDecodedPixels {
+		return header, 0, fmt.Errorf("exr: dataWindow of %dx%d is too large", width, height)
+	}
+	return header, offset, nil
+}
+
+func readEXRChannels(value []byte) (channels []exrChannel, err error) {
+	offset := 0
+	for {
+		end := bytes.IndexByte(value[offset:], 0)
+		if end < 0 {
+			return nil, fmt.Errorf("exr: truncated channel list")
+		}
+		name := string(value[offset : offset+end])
+		offset += end + 1
+		if name == "" {
+			break
+		}
+		if offset+16 > len(value) {
+			return nil, fmt.Errorf("exr: truncated channel list")
+		}
+
+		channel := exrChannel{
+			name:      name,
+			pixelType: exrPixelType(binary.LittleEndian.Uint32(value[offset:])),
+			xSampling: int32(binary.LittleEndian.Uint32(value[offset+8:])),
+			ySampling: int32(binary.LittleEndian.Uint32(value[offset+12:])),
+		}
+		offset += 16
+
+		if channel.pixelType < exrUint || channel.pixelType > exrFloat {
+			return nil, fmt.Errorf("exr: channel %s has invalid pixel type %d", name, channel.pixelType)
+		}
+		if channel.xSampling != 1 || channel.ySampling != 1 {
+			return nil, fmt.Errorf("exr: channel %s is subsampled, which is not supported", name)
+		}
+		channels = append(channels, channel)
+	}
+	return channels, nil
+}
+
+func decompressEXRChunk(header *exrHeader, packed []byte, unpackedSize int, lines int) ([]byte, error) {
+	switch header.compression {
+	case EXRRLECompression:
+		unpacked, err := rleDecompress(packed, unpackedSize)
+		if err != nil {
+			return nil, err
+		}
+		return exrUnpredict(unpacked), nil
+	case EXRZIPSCompression, EXRZIPCompression:
+		unpacked, err := zlibDecompress(packed, unpackedSize)
+		if err != nil {
+			return nil, err
+		}
+		return exrUnpredict(unpacked), nil
+	case EXRPIZCompression:
+		return pizDecompress(packed, unpackedSize, header.channels, header.dataWindow.Dx(), lines)
+	}
+	return nil, fmt.Errorf("exr: unsupported compression %d", header.compression)
+}
+
+// EncodeEXR writes m as a scanline OpenEXR image with R, G, B and A channels. PIZ compression is
+// only supported for decoding.
+func EncodeEXR(w io.Writer, m image.Image, o *EXROptions) error {
+	var options EXROptions
+	if o != nil {
+		options = *o
+	}
+	if options.Compression > EXRZIPCompression {
+		return fmt.Errorf("exr: unsupported compression %d for encoding", options.Compression)
+	}
+
+	pixelType := exrHalf
+	if options.Float {
+		pixelType = exrFloat
+	}
+
+	bounds := m.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return fmt.Errorf("exr: cannot encode an empty image")
+	}
+
+	// channels are stored in alphabetical order
+	channelNames := []string{"A", "B", "G", "R"}
+	channelComponents := []int{3, 2, 1, 0}
+
+	var header bytes.Buffer
+	header.WriteString(kEXRMagic)
+	binary.Write(&header, binary.LittleEndian, uint32(2))
+
+	var channels bytes.Buffer
+	for _, name := range channelNames {
+		channels.WriteString(name)
+		channels.WriteByte(0)
+		binary.Write(&channels, binary.LittleEndian, int32(pixelType))
+		channels.Write([]byte{0, 0, 0, 0})
+		binary.Write(&channels, binary.LittleEndian, int32(1))
+		binary.Write(&channels, binary.LittleEndian, int32(1))
+	}
+	channels.WriteByte(0)
+
+	var window bytes.Buffer
+	binary.Write(&window, binary.LittleEndian, []int32{0, 0, int32(width - 1), int32(height - 1)})
+
+	writeEXRAttribute(&header, "channels", "chlist", channels.Bytes())
+	writeEXRAttribute(&header, "compression", "compression", []byte{byte(options.Compression)})
+	writeEXRAttribute(&header, "dataWindow", "box2i", window.Bytes())
+	writeEXRAttribute(&header, "displayWindow", "box2i", window.Bytes())
+	writeEXRAttribute(&header, "lineOrder", "lineOrder", []byte{0})
+	writeEXRAttribute(&header, "pixelAspectRatio", "float", float32Bytes(1))
+	writeEXRAttribute(&header, "screenWindowCenter", "v2f", append(float32Bytes(0), float32Bytes(0)...))
+	writeEXRAttribute(&header, "screenWindowWidth", "float", float32Bytes(1))
+	header.WriteByte(0)
+
+	linesPerChunk := options.Compression.linesPerChunk()
+	chunkCount := (height + linesPerChunk - 1) / linesPerChunk
+	offsets := make([]uint64, chunkCount)
+	var chunks bytes.Buffer
+	chunksStart := header.Len() + chunkCount*8
+
+	for i := 0; i < chunkCount; i++ {
+		y := i * linesPerChunk
+		lines := linesPerChunk
+		if y+lines > height {
+			lines = height - y
+		}
+
+		var unpacked bytes.Buffer
+		for line := y; line < y+lines; line++ {
+			for _, component := range channelComponents {
+				for x := 0; x < width; x++ {
+					v := floatComponent(m, bounds.Min.X+x, bounds.Min.Y+line, component)
+					if pixelType == exrHalf {
+						binary.Write(&unpacked, binary.LittleEndian, float32ToHalf(v))
+					} else {
+						binary.Write(&unpacked, binary.LittleEndian, v)
+					}
+				}
+			}
+		}
+
+		packed := unpacked.Bytes()
+		switch options.Compression {
+		case EXRRLECompression:
+			packed = rleCompress(exrPredict(unpacked.Bytes()))
+		case EXRZIPSCompression, EXRZIPCompression:
+			compressed, err := zlibCompress(exrPredict(unpacked.Bytes()))
+			if err != nil {
+				return err
+			}
+			packed = compressed
+		}
+		if len(packed) >= unpacked.Len() {
+			packed = unpacked.Bytes()
+		}
+
+		offsets[i] = uint64(chunksStart + chunks.Len())
+		binary.Write(&chunks, binary.LittleEndian, int32(y))
+		binary.Write(&chunks, binary.LittleEndian, int32(len(packed)))
+		chunks.Write(packed)
+	}
+
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, offsets); err != nil {
+		return err
+	}
+	_, err := w.Write(chunks.Bytes())
+	return err
+}
+
+func writeEXRAttribute(buffer *bytes.Buffer, name, typeName string, value []byte) {
+	buffer.WriteString(name)
+	buffer.WriteByte(0)
+	buffer.WriteString(typeName)
+	buffer.WriteByte(0)
+	binary.Write(buffer, binary.LittleEndian, int32(len(value)))
+	buffer.Write(value)
+}
+
+func float32Bytes(v float32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, math.Float32bits(v))
+	return b
+}
+
+// floatComponent reads one channel of a pixel as a float, without clamping float images.
+func floatComponent(m image.Image, x, y int, component int) float32 {
+	if floatImage, ok := m.(*FloatImage); ok {
+		return floatImage.Pix[floatImage.PixOffset(x, y)+component]
+	}
+	r, g, b, a := m.At(x, y).RGBA()
+	return float32([]uint32{r, g, b, a}[component]) / 0xffff
+}
+
+func halfToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exponent := uint32(h>>10) & 0x1f
+	mantissa := uint32(h) & 0x3ff
+
+	switch exponent {
+	case 0:
+		// zero or subnormal
+		v := float32(mantissa) / (1 << 24)
+		if sign != 0 {
+			return -v
+		}
+		return v
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mantissa<<13)
+	}
+	return math.Float32frombits(sign | (exponent+112)<<23 | mantissa<<13)
+}
+
+// float32ToHalf converts with round-to-nearest-even, saturating to infinity.
+func float32ToHalf(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exponent := int((bits>>23)&0xff) - 127 + 15
+	mantissa := bits & 0x7fffff
+
+	if (bits>>23)&0xff == 0xff {
+		if mantissa != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	}
+	if exponent >= 0x1f {
+		return sign | 0x7c00
+	}
+	if exponent <= 0 {
+		if exponent < -10 {
+			return sign
+		}
+		mantissa |= 0x800000
+		shift := uint(14 - exponent)
+		half := mantissa >> shift
+		roundBit := uint32(1) << (shift - 1)
+		if mantissa&roundBit != 0 && (mantissa&(roundBit-1) != 0 || half&1 != 0) {
+			half++
+		}
+		return sign | uint16(half)
+	}
+
+	half := uint32(exponent)<<10 | mantissa>>13
+	if mantissa&0x1000 != 0 && mantissa&0x2fff != 0 {
+		half++
+	}
+	return sign | uint16(half)
+}
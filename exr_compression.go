@@ -0,0 +1,561 @@
+package glslfilter
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// exrUnpredict reverses the byte delta predictor and the even/odd byte split that OpenEXR applies
+// before RLE and ZIP compression.
+func exrUnpredict(data []byte) []byte {
+	for i := 1; i < len(data); i++ {
+		data[i] = data[i-1] + data[i] - 128
+	}
+
+	out := make([]byte, len(data))
+	half := (len(data) + 1) / 2
+	for i := range out {
+		if i%2 == 0 {
+			out[i] = data[i/2]
+		} else {
+			out[i] = data[half+i/2]
+		}
+	}
+	return out
+}
+
+func exrPredict(data []byte) []byte {
+	out := make([]byte, len(data))
+	half := (len(data) + 1) / 2
+	for i, b := range data {
+		if i%2 == 0 {
+			out[i/2] = b
+		} else {
+			out[half+i/2] = b
+		}
+	}
+
+	previous := out[0]
+	for i := 1; i < len(out); i++ {
+		current := out[i]
+		out[i] = current - previous + 128
+		previous = current
+	}
+	return out
+}
+
+func rleDecompress(packed []byte, unpackedSize int) ([]byte, error) {
+	out := make([]byte, 0, unpackedSize)
+	for i := 0; i < len(packed); {
+		count := int(int8(packed[i]))
+		i++
+		if count < 0 {
+			count = -count
+			if i+count > len(packed) {
+				return nil, fmt.Errorf("exr: truncated RLE data")
+			}
+			out = append(out, packed[i:i+count]...)
+			i += count
+		} else {
+			if i >= len(packed) {
+				return nil, fmt.Errorf("exr: truncated RLE data")
+			}
+			for j := 0; j <= count; j++ {
+				out = append(out, packed[i])
+			}
+			i++
+		}
+		if len(out) > unpackedSize {
+			return nil, fmt.Errorf("exr: RLE data overflows chunk")
+		}
+	}
+	return out, nil
+}
+
+func rleCompress(data []byte) []byte {
+	const minRunLength = 3
+	const maxRunLength = 127
+
+	var out []byte
+	runStart := 0
+	runEnd := 1
+	for runStart < len(data) {
+		for runEnd < len(data) && data[runStart] == data[runEnd] && runEnd-runStart-1 < maxRunLength {
+			runEnd++
+		}
+
+		if runEnd-runStart >= minRunLength {
+			out = append(out, byte(runEnd-runStart-1), data[runStart])
+			runStart = runEnd
+		} else {
+			// extend the literal run until three equal bytes start a new run
+			for runEnd < len(data) &&
+				(runEnd+1 >= len(data) || data[runEnd] != data[runEnd+1] ||
+					runEnd+2 >= len(data) || data[runEnd+1] != data[runEnd+2]) &&
+				runEnd-runStart < maxRunLength {
+				runEnd++
+			}
+			out = append(out, byte(int8(runStart-runEnd)))
+			out = append(out, data[runStart:runEnd]...)
+			runStart = runEnd
+		}
+		runEnd++
+	}
+	return out
+}
+
+func zlibDecompress(packed []byte, unpackedSize int) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	out := make([]byte, unpackedSize)
+	if _, err = io.ReadFull(reader, out); err != nil {
+		return nil, fmt.Errorf("exr: %w", err)
+	}
+	return out, nil
+}
+
+func zlibCompress(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := zlib.NewWriter(&buffer)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+const (
+	kPIZBitmapSize   = 8192
+	kPIZUShortRange  = 1 << 16
+	kHufEncBits      = 16
+	kHufDecBits      = 14
+	kHufEncSize      = (1 << kHufEncBits) + 1
+	kHufDecSize      = 1 << kHufDecBits
+	kHufDecMask      = kHufDecSize - 1
+	kShortZeroRun    = 59
+	kLongZeroRun     = 63
+	kShortestLongRun = 2 + kLongZeroRun - kShortZeroRun
+)
+
+// pizDecompress decodes a PIZ chunk: a Huffman-coded, Haar wavelet transformed block of 16-bit
+// values remapped through a lookup table of the values actually present.
+func pizDecompress(packed []byte, unpackedSize int, channels []exrChannel, width int, lines int) ([]byte, error) {
+	if len(packed) < 4 {
+		return nil, fmt.Errorf("exr: truncated PIZ data")
+	}
+
+	bitmap := make([]byte, kPIZBitmapSize)
+	minNonZero := int(binary.LittleEndian.Uint16(packed[0:]))
+	maxNonZero := int(binary.LittleEndian.Uint16(packed[2:]))
+	pos := 4
+	if maxNonZero >= kPIZBitmapSize {
+		return nil, fmt.Errorf("exr: invalid PIZ bitmap range")
+	}
+	if minNonZero <= maxNonZero {
+		n := maxNonZero - minNonZero + 1
+		if pos+n > len(packed) {
+			return nil, fmt.Errorf("exr: truncated PIZ bitmap")
+		}
+		copy(bitmap[minNonZero:], packed[pos:pos+n])
+		pos += n
+	}
+
+	lut := make([]uint16, kPIZUShortRange)
+	maxValue := pizReverseLUT(bitmap, lut)
+
+	if pos+4 > len(packed) {
+		return nil, fmt.Errorf("exr: truncated PIZ data")
+	}
+	length := int(int32(binary.LittleEndian.Uint32(packed[pos:])))
+	pos += 4
+	if length < 0 || pos+length > len(packed) {
+		return nil, fmt.Errorf("exr: truncated PIZ data")
+	}
+
+	values := make([]uint16, unpackedSize/2)
+	if err := hufUncompress(packed[pos:pos+length], values); err != nil {
+		return nil, err
+	}
+
+	start := 0
+	channelStarts := make([]int, len(channels))
+	for i, channel := range channels {
+		size := channel.pixelType.size() / 2
+		channelStarts[i] = start
+		for j := 0; j < size; j++ {
+			wav2Decode(values[start+j:], width, size, lines, width*size, maxValue)
+		}
+		start += width * lines * size
+	}
+
+	for i, v := range values {
+		values[i] = lut[v]
+	}
+
+	out := make([]byte, 0, unpackedSize)
+	for line := 0; line < lines; line++ {
+		for i, channel := range channels {
+			n := width * channel.pixelType.size() / 2
+			for _, v := range values[channelStarts[i] : channelStarts[i]+n] {
+				out = append(out, byte(v), byte(v>>8))
+			}
+			channelStarts[i] += n
+		}
+	}
+	return out, nil
+}
+
+func pizReverseLUT(bitmap []byte, lut []uint16) (maxValue uint16) {
+	k := 0
+	for i := 0; i < kPIZUShortRange; i++ {
+		if i == 0 || bitmap[i>>3]&(1<<(i&7)) != 0 {
+			lut[k] = uint16(i)
+			k++
+		}
+	}
+	maxValue = uint16(k - 1)
+	for ; k < kPIZUShortRange; k++ {
+		lut[k] = 0
+	}
+	return maxValue
+}
+
+func wav2Decode(in []uint16, nx, ox, ny, oy int, maxValue uint16) {
+	w14 := maxValue < (1 << 14)
+	n := nx
+	if ny < n {
+		n = ny
+	}
+
+	p := 1
+	for p <= n {
+		p <<= 1
+	}
+	p >>= 1
+	p2 := p
+	p >>= 1
+
+	decode := wdec16
+	if w14 {
+		decode = wdec14
+	}
+
+	for p >= 1 {
+		oy1 := oy * p
+		oy2 := oy * p2
+		ox1 := ox * p
+		ox2 := ox * p2
+		ey := oy * (ny - p2)
+
+		py := 0
+		for ; py <= ey; py += oy2 {
+			ex := py + ox*(nx-p2)
+			px := py
+			for ; px <= ex; px += ox2 {
+				p01 := px + ox1
+				p10 := px + oy1
+				p11 := p10 + ox1
+
+				i00, i10 := decode(in[px], in[p10])
+				i01, i11 := decode(in[p01], in[p11])
+				in[px], in[p01] = decode(i00, i01)
+				in[p10], in[p11] = decode(i10, i11)
+			}
+
+			if nx&p != 0 {
+				p10 := px + oy1
+				var i00 uint16
+				i00, in[p10] = decode(in[px], in[p10])
+				in[px] = i00
+			}
+		}
+
+		if ny&p != 0 {
+			ex := py + ox*(nx-p2)
+			px := py
+			for ; px <= ex; px += ox2 {
+				p01 := px + ox1
+				var i00 uint16
+				i00, in[p01] = decode(in[px], in[p01])
+				in[px] = i00
+			}
+		}
+
+		p2 = p
+		p >>= 1
+	}
+}
+
+func wdec14(l, h uint16) (a, b uint16) {
+	ls := int16(l)
+	hs := int16(h)
+	hi := int(hs)
+	ai := int(ls) + (hi & 1) + (hi >> 1)
+	return uint16(int16(ai)), uint16(int16(ai - hi))
+}
+
+func wdec16(l, h uint16) (a, b uint16) {
+	const aOffset = 1 << 15
+	const modMask = (1 << 16) - 1
+	m := int(l)
+	d := int(h)
+	bb := (m - (d >> 1)) & modMask
+	aa := (d + bb - aOffset) & modMask
+	return uint16(aa), uint16(bb)
+}
+
+type hufDec struct {
+	length  int
+	literal int
+	codes   []int
+}
+
+type hufBitReader struct {
+	data []byte
+	pos  int
+	c    uint64
+	lc   int
+}
+
+func (reader *hufBitReader) readBits(n int) (uint64, error) {
+	for reader.lc < n {
+		if reader.pos >= len(reader.data) {
+			return 0, fmt.Errorf("exr: truncated Huffman table")
+		}
+		reader.c = reader.c<<8 | uint64(reader.data[reader.pos])
+		reader.pos++
+		reader.lc += 8
+	}
+	reader.lc -= n
+	return (reader.c >> uint(reader.lc)) & (1<<uint(n) - 1), nil
+}
+
+func hufUncompress(compressed []byte, out []uint16) error {
+	if len(out) == 0 {
+		return nil
+	}
+	if len(compressed) < 20 {
+		return fmt.Errorf("exr: truncated Huffman data")
+	}
+
+	im := int(binary.LittleEndian.Uint32(compressed[0:]))
+	iM := int(binary.LittleEndian.Uint32(compressed[4:]))
+	nBits := int(binary.LittleEndian.Uint32(compressed[12:]))
+	if im < 0 || im >= kHufEncSize || iM < 0 || iM >= kHufEncSize {
+		return fmt.Errorf("exr: invalid Huffman table size")
+	}
+
+	reader := &hufBitReader{data: compressed[20:]}
+	codes := make([]uint64, kHufEncSize)
+	if err := hufUnpackEncTable(reader, im, iM, codes); err != nil {
+		return err
+	}
+	if nBits > 8*(len(compressed)-20-reader.pos) {
+		return fmt.Errorf("exr: truncated Huffman data")
+	}
+
+	decodeTable, err := hufBuildDecTable(codes, im, iM)
+	if err != nil {
+		return err
+	}
+	return hufDecode(codes, decodeTable, compressed[20+reader.pos:], nBits, iM, out)
+}
+
+func hufUnpackEncTable(reader *hufBitReader, im, iM int, codes []uint64) error {
+	for ; im <= iM; im++ {
+		length, err := reader.readBits(6)
+		if err != nil {
+			return err
+		}
+		codes[im] = length
+
+		if length == kLongZeroRun {
+			zeroRun, err := reader.readBits(8)
+			if err != nil {
+				return err
+			}
+			zeroRun += kShortestLongRun
+			if im+int(zeroRun) > iM+1 {
+				return fmt.Errorf("exr: invalid Huffman table")
+			}
+			for ; zeroRun > 0; zeroRun-- {
+				codes[im] = 0
+				im++
+			}
+			im--
+		} else if length >= kShortZeroRun {
+			zeroRun := int(length) - kShortZeroRun + 2
+			if im+zeroRun > iM+1 {
+				return fmt.Errorf("exr: invalid Huffman table")
+			}
+			for ; zeroRun > 0; zeroRun-- {
+				codes[im] = 0
+				im++
+			}
+			im--
+		}
+	}
+	hufCanonicalCodeTable(codes)
+	return nil
+}
+
+// hufCanonicalCodeTable turns code lengths into canonical codes, packing each as
+// code<<6 | length.
+func hufCanonicalCodeTable(codes []uint64) {
+	var counts [59]uint64
+	for _, length := range codes {
+		counts[length]++
+	}
+
+	var c uint64
+	for i := 58; i > 0; i-- {
+		next := (c + counts[i]) >> 1
+		counts[i] = c
+		c = next
+	}
+
+	for i, length := range codes {
+		if length > 0 {
+			codes[i] = length | counts[length]<<6
+			counts[length]++
+		}
+	}
+}
+
+func hufBuildDecTable(codes []uint64, im, iM int) ([]hufDec, error) {
+	decodeTable := make([]hufDec, kHufDecSize)
+	for ; im <= iM; im++ {
+		c := codes[im] >> 6
+		length := int(codes[im] & 63)
+		if c>>uint(length) != 0 {
+			return nil, fmt.Errorf("exr: invalid Huffman table")
+		}
+
+		if length > kHufDecBits {
+			entry := &decodeTable[c>>uint(length-kHufDecBits)]
+			if entry.length != 0 {
+				return nil, fmt.Errorf("exr: invalid Huffman table")
+			}
+			entry.literal++
+			entry.codes = append(entry.codes, im)
+		} else if length > 0 {
+			first := int(c << uint(kHufDecBits-length))
+			for i := 0; i < 1<<uint(kHufDecBits-length); i++ {
+				entry := &decodeTable[first+i]
+				if entry.length != 0 || entry.codes != nil {
+					return nil, fmt.Errorf("exr: invalid Huffman table")
+				}
+				entry.length = length
+				entry.literal = im
+			}
+		}
+	}
+	return decodeTable, nil
+}
+
+func hufDecode(codes []uint64, decodeTable []hufDec, in []byte, nBits int, runLengthCode int, out []uint16) error {
+	var c uint64
+	lc := 0
+	pos := 0
+	outPos := 0
+	end := (nBits + 7) / 8
+
+	emit := func(symbol int) error {
+		if symbol == runLengthCode {
+			if lc < 8 {
+				if pos >= end {
+					return fmt.Errorf("exr: truncated Huffman data")
+				}
+				c = c<<8 | uint64(in[pos])
+				pos++
+				lc += 8
+			}
+			lc -= 8
+			count := int(byte(c >> uint(lc)))
+			if outPos+count > len(out) || outPos == 0 {
+				return fmt.Errorf("exr: invalid Huffman run")
+			}
+			previous := out[outPos-1]
+			for ; count > 0; count-- {
+				out[outPos] = previous
+				outPos++
+			}
+		} else {
+			if outPos >= len(out) {
+				return fmt.Errorf("exr: Huffman data overflows chunk")
+			}
+			out[outPos] = uint16(symbol)
+			outPos++
+		}
+		return nil
+	}
+
+	for pos < end {
+		c = c<<8 | uint64(in[pos])
+		pos++
+		lc += 8
+
+		for lc >= kHufDecBits {
+			entry := decodeTable[(c>>uint(lc-kHufDecBits))&kHufDecMask]
+			if entry.length != 0 {
+				lc -= entry.length
+				if err := emit(entry.literal); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if entry.codes == nil {
+				return fmt.Errorf("exr: invalid Huffman code")
+			}
+			found := false
+			for _, symbol := range entry.codes {
+				length := int(codes[symbol] & 63)
+				for lc < length && pos < end {
+					c = c<<8 | uint64(in[pos])
+					pos++
+					lc += 8
+				}
+				if lc >= length && codes[symbol]>>6 == (c>>uint(lc-length))&(1<<uint(length)-1) {
+					lc -= length
+					if err := emit(symbol); err != nil {
+						return err
+					}
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("exr: invalid Huffman code")
+			}
+		}
+	}
+
+	padding := (8 - nBits) & 7
+	c >>= uint(padding)
+	lc -= padding
+	for lc > 0 {
+		entry := decodeTable[(c<<uint(kHufDecBits-lc))&kHufDecMask]
+		if entry.length == 0 {
+			return fmt.Errorf("exr: invalid Huffman code")
+		}
+		lc -= entry.length
+		if err := emit(entry.literal); err != nil {
+			return err
+		}
+	}
+
+	if outPos != len(out) {
+		return fmt.Errorf("exr: Huffman data decoded to %d values, expected %d", outPos, len(out))
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+package glslfilter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// testFloatImage returns an image with runs, gradients, negative and HDR values, all exactly
+// representable as half floats.
+func testFloatImage(width, height int) *FloatImage {
+	img := NewFloatImage(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r := float32(x) / 8
+			g := float32(y%4) - 2
+			b := float32(0.5)
+			if x > width/2 {
+				b = 300
+			}
+			img.SetFloat(x, y, r, g, b, float32(x+y)/64)
+		}
+	}
+	return img
+}
+
+func TestEXRRoundTrip(t *testing.T) {
+	compressions := []EXRCompression{EXRNoCompression, EXRRLECompression, EXRZIPSCompression, EXRZIPCompression}
+	for _, compression := range compressions {
+		for _, float := range []bool{false, true} {
+			// 37 lines leaves a partial chunk for ZIP's 16 lines per chunk
+			want := testFloatImage(19, 37)
+			var buffer bytes.Buffer
+			if err := EncodeEXR(&buffer, want, &EXROptions{Compression: compression, Float: float}); err != nil {
+				t.Fatalf("compression %d, float %v: encode: %v", compression, float, err)
+			}
+			got, err := DecodeEXR(&buffer)
+			if err != nil {
+				t.Fatalf("compression %d, float %v: decode: %v", compression, float, err)
+			}
+			if !bytes.Equal(float32sBytes(got.(*FloatImage).Pix), float32sBytes(want.Pix)) || got.Bounds() != want.Bounds() {
+				t.Errorf("compression %d, float %v: decoded image differs", compression, float)
+			}
+		}
+	}
+}
+
+func TestEXRRoundTripNonFloatImage(t *testing.T) {
+	want := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	want.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	want.Set(2, 1, color.RGBA{0, 51, 0, 255})
+
+	var buffer bytes.Buffer
+	if err := EncodeEXR(&buffer, want, &EXROptions{Float: true}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeEXR(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _, a := got.(*FloatImage).FloatAt(0, 0); r != 1 || a != 1 {
+		t.Errorf("(0, 0) = %v, %v, want 1, 1", r, a)
+	}
+	if _, g, _, _ := got.(*FloatImage).FloatAt(2, 1); g != 0.2 {
+		t.Errorf("(2, 1) green = %v, want 0.2", g)
+	}
+}
+
+func TestDecodeEXRPIZ(t *testing.T) {
+	// 37x45 with half A, B, G and a float R channel, in a 32 line chunk and a partial one
+	file, err := os.Open("testdata/piz.exr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	m, _, err := image.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := m.(*FloatImage)
+	if img.Bounds() != image.Rect(0, 0, 37, 45) {
+		t.Fatalf("bounds = %v, want 37x45", img.Bounds())
+	}
+	for y := 0; y < 45; y++ {
+		for x := 0; x < 37; x++ {
+			r, g, b, a := img.FloatAt(x, y)
+			wantR, wantG, wantB, wantA := float32(x)/4+float32(y), float32(y)/64, float32(x+y)/128, 1-float32(x)/128
+			if r != wantR || g != wantG || b != wantB || a != wantA {
+				t.Fatalf("(%d, %d) = %v %v %v %v, want %v %v %v %v", x, y, r, g, b, a, wantR, wantG, wantB, wantA)
+			}
+		}
+	}
+}
+
+func TestDecodeEXRRejectsInvalidFiles(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := EncodeEXR(&buffer, testFloatImage(4, 4), nil); err != nil {
+		t.Fatal(err)
+	}
+	valid := buffer.Bytes()
+	_, offset, err := readEXRHeader(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(data []byte) []byte
+		want   string
+	}{
+		{"not an EXR", func(data []byte) []byte { return []byte("GIF89a") }, "not an OpenEXR file"},
+		{"truncated header", func(data []byte) []byte { return data[:40] }, "truncated"},
+		{"PXR24 compression", func(data []byte) []byte {
+			data[attributeValueOffset(t, data, "compression")] = 5
+			return data
+		}, "unsupported compression 5"},
+		{"huge dataWindow", func(data []byte) []byte {
+			window := attributeValueOffset(t, data, "dataWindow")
+			binary.LittleEndian.PutUint32(data[window+8:], math.MaxInt32)
+			binary.LittleEndian.PutUint32(data[window+12:], math.MaxInt32)
+			return data
+		}, "too large"},
+		{"truncated offset table", func(data []byte) []byte { return data[:offset+8] }, "truncated offset table"},
+		{"overflowing chunk offset", func(data []byte) []byte {
+			binary.LittleEndian.PutUint64(data[offset:], math.MaxUint64-4)
+			return data
+		}, "invalid chunk offset"},
+		{"chunk past the end", func(data []byte) []byte {
+			chunk := binary.LittleEndian.Uint64(data[offset:])
+			binary.LittleEndian.PutUint32(data[chunk+4:], 1<<20)
+			return data
+		}, "invalid chunk"},
+	}
+	for _, test := range tests {
+		data := test.modify(append([]byte(nil), valid...))
+		if _, err := DecodeEXR(bytes.NewReader(data)); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.want)
+		}
+	}
+}
+
+func TestHalfConversion(t *testing.T) {
+	tests := []struct {
+		f    float32
+		half uint16
+	}{
+		{0, 0x0000},
+		{float32(math.Copysign(0, -1)), 0x8000},
+		{1, 0x3c00},
+		{-2, 0xc000},
+		{0.1, 0x2e66},
+		{65504, 0x7bff},
+		{65520, 0x7c00},
+		{float32(math.Inf(-1)), 0xfc00},
+		{5.960464477539063e-8, 0x0001},
+		{1e-9, 0x0000},
+		// ties round to even
+		{1 + 1.0/2048, 0x3c00},
+		{1 + 3.0/2048, 0x3c02},
+	}
+	for _, test := range tests {
+		if got := float32ToHalf(test.f); got != test.half {
+			t.Errorf("float32ToHalf(%v) = %#04x, want %#04x", test.f, got, test.half)
+		}
+	}
+	if got := float32ToHalf(float32(math.NaN())); got&0x7c00 != 0x7c00 || got&0x3ff == 0 {
+		t.Errorf("float32ToHalf(NaN) = %#04x, want a NaN", got)
+	}
+
+	for h := 0; h < 1<<16; h++ {
+		if h&0x7c00 == 0x7c00 && h&0x3ff != 0 {
+			continue
+		}
+		if got := float32ToHalf(halfToFloat32(uint16(h))); got != uint16(h) {
+			t.Fatalf("half %#04x converts back to %#04x", h, got)
+		}
+	}
+}
+
+func TestEXRCompressionRoundTrip(t *testing.T) {
+	data := []byte(strings.Repeat("a", 300) + "abcdefgh" + strings.Repeat("\x00", 3) + "xyz")
+	if got, err := rleDecompress(rleCompress(data), len(data)); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("RLE round trip = %q, %v", got, err)
+	}
+	if _, err := rleDecompress(rleCompress(data), len(data)-1); err == nil {
+		t.Error("RLE data decompressing past its size was accepted")
+	}
+	if got := exrUnpredict(exrPredict(data)); !bytes.Equal(got, data) {
+		t.Errorf("predictor round trip = %q", got)
+	}
+	compressed, err := zlibCompress(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := zlibDecompress(compressed, len(data)); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("zlib round trip = %q, %v", got, err)
+	}
+}
+
+// attributeValueOffset returns the offset of the value of a header attribute written by EncodeEXR.
+func attributeValueOffset(t *testing.T, data []byte, name string) int {
+	t.Helper()
+	i := bytes.Index(data, []byte(name+"\x00"))
+	if i < 0 {
+		t.Fatalf("no %s attribute", name)
+	}
+	i += len(name) + 1
+	i += bytes.IndexByte(data[i:], 0) + 1
+	return i + 4
+}
+
+func float32sBytes(values []float32) []byte {
+	b := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(b[i*4:], math.Float32bits(v))
+	}
+	return b
+}
+
+// TestDecodeEXRAllocatesForThePixelData checks that a small file declaring a large dataWindow is
+// rejected before the image is allocated.
+func TestDecodeEXRAllocatesForThePixelData(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression EXRCompression
+		modify      func(data []byte, window int) []byte
+		want        string
+	}{
+		{"uncompressed", EXRNoCompression, func(data []byte, window int) []byte {
+			binary.LittleEndian.PutUint32(data[window+12:], 4095)
+			return append(data, make([]byte, 4096*8)...)
+		}, "truncated pixel data"},
+		{"ZIP", EXRZIPCompression, func(data []byte, window int) []byte {
+			binary.LittleEndian.PutUint32(data[window+8:], 1<<22-1)
+			return data
+		}, "too small"},
+	}
+	for _, test := range tests {
+		var buffer bytes.Buffer
+		if err := EncodeEXR(&buffer, testFloatImage(4, 4), &EXROptions{Compression: test.compression}); err != nil {
+			t.Fatal(err)
+		}
+		data := test.modify(buffer.Bytes(), attributeValueOffset(t, buffer.Bytes(), "dataWindow"))
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := DecodeEXR(bytes.NewReader(data))
+		runtime.ReadMemStats(&after)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.want)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<24 {
+			t.Errorf("%s: allocated %d bytes", test.name, allocated)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package glslfilter
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// kMaxDecodedPixels limits the size of the images the EXR and HDR decoders allocate, 1 GiB as a
+// FloatImage.
+const kMaxDecodedPixels = 1 << 26
+
+// FloatImage is an in-memory image of 32-bit float RGBA channels. Unlike the standard library
+// image types, values outside [0, 1] are kept; they are only clamped when read through At.
+type FloatImage struct {
+	Pix    []float32
+	Stride int
+	Rect   image.Rectangle
+}
+
+func NewFloatImage(r image.Rectangle) *FloatImage {
+	return &FloatImage{
+		Pix:    make([]float32, 4*r.Dx()*r.Dy()),
+		Stride: 4 * r.Dx(),
+		Rect:   r,
+	}
+}
+
+func (img *FloatImage) ColorModel() color.Model {
+	return color.RGBA64Model
+}
+
+func (img *FloatImage) Bounds() image.Rectangle {
+	return img.Rect
+}
+
+func (img *FloatImage) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(img.Rect)) {
+		return color.RGBA64{}
+	}
+	r, g, b, a := img.FloatAt(x, y)
+	return color.RGBA64{clampToUint16(r), clampToUint16(g), clampToUint16(b), clampToUint16(a)}
+}
+
+func (img *FloatImage) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(img.Rect)) {
+		return
+	}
+	r, g, b, a := c.RGBA()
+	img.SetFloat(x, y, float32(r)/0xffff, float32(g)/0xffff, float32(b)/0xffff, float32(a)/0xffff)
+}
+
+func (img *FloatImage) FloatAt(x, y int) (r, g, b, a float32) {
+	if !(image.Point{x, y}.In(img.Rect)) {
+		return 0, 0, 0, 0
+	}
+	i := img.PixOffset(x, y)
+	s := img.Pix[i : i+4 : i+4]
+	return s[0], s[1], s[2], s[3]
+}
+
+func (img *FloatImage) SetFloat(x, y int, r, g, b, a float32) {
+	if !(image.Point{x, y}.In(img.Rect)) {
+		return
+	}
+	i := img.PixOffset(x, y)
+	s := img.Pix[i : i+4 : i+4]
+	s[0], s[1], s[2], s[3] = r, g, b, a
+}
+
+func (img *FloatImage) PixOffset(x, y int) int {
+	return (y-img.Rect.Min.Y)*img.Stride + (x-img.Rect.Min.X)*4
+}
+
+func clampToUint16(v float32) uint16 {
+	if !(v > 0) {
+		return 0
+	}
+	if v >= 1 {
+		return 0xffff
+	}
+	return uint16(math.Round(float64(v) * 0xffff))
+}
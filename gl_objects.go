@@ -0,0 +1,157 @@
+package glslfilter
+
+import (
+	"sync"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+// GLObjectCounts is the number of GL objects of each kind created by the package that haven't
+// been deleted yet.
+type GLObjectCounts struct {
+	Programs     int
+	Shaders      int
+	Textures     int
+	Buffers      int
+	VertexArrays int
+	Framebuffers int
+	Samplers     int
+}
+
+type glObjectKind int
+
+const (
+	kGLProgram glObjectKind = iota
+	kGLShader
+	kGLTexture
+	kGLBuffer
+	kGLVertexArray
+	kGLFramebuffer
+	kGLSampler
+	kGLObjectKindCount
+)
+
+var liveGLObjects struct {
+	sync.Mutex
+	counts [kGLObjectKindCount]int
+}
+
+func trackGLObject(kind glObjectKind, delta int) {
+	liveGLObjects.Lock()
+	liveGLObjects.counts[kind] += delta
+	liveGLObjects.Unlock()
+}
+
+// LiveGLObjects reports the GL objects created by every engine and stage that are still alive,
+// which should drop back to zero once they've all been closed.
+func LiveGLObjects() GLObjectCounts {
+	liveGLObjects.Lock()
+	defer liveGLObjects.Unlock()
+	return GLObjectCounts{
+		Programs:     liveGLObjects.counts[kGLProgram],
+		Shaders:      liveGLObjects.counts[kGLShader],
+		Textures:     liveGLObjects.counts[kGLTexture],
+		Buffers:      liveGLObjects.counts[kGLBuffer],
+		VertexArrays: liveGLObjects.counts[kGLVertexArray],
+		Framebuffers: liveGLObjects.counts[kGLFramebuffer],
+		Samplers:     liveGLObjects.counts[kGLSampler],
+	}
+}
+
+func createProgramObject() (name uint32) {
+	name = gl.CreateProgram()
+	trackGLObject(kGLProgram, 1)
+	return name
+}
+
+func deleteProgramObject(name uint32) {
+	if name == 0 {
+		return
+	}
+	gl.DeleteProgram(name)
+	trackGLObject(kGLProgram, -1)
+}
+
+func createShaderObject(shaderType uint32) (name uint32) {
+	name = gl.CreateShader(shaderType)
+	trackGLObject(kGLShader, 1)
+	return name
+}
+
+func deleteShaderObject(name uint32) {
+	if name == 0 {
+		return
+	}
+	gl.DeleteShader(name)
+	trackGLObject(kGLShader, -1)
+}
+
+func createTextureObject() (name uint32) {
+	gl.CreateTextures(gl.TEXTURE_2D, 1, &name)
+	trackGLObject(kGLTexture, 1)
+	return name
+}
+
+func deleteTextureObject(name uint32) {
+	if name == 0 {
+		return
+	}
+	gl.DeleteTextures(1, &name)
+	trackGLObject(kGLTexture, -1)
+}
+
+func createBufferObject() (name uint32) {
+	gl.CreateBuffers(1, &name)
+	trackGLObject(kGLBuffer, 1)
+	return name
+}
+
+func deleteBufferObject(name uint32) {
+	if name == 0 {
+		return
+	}
+	gl.DeleteBuffers(1, &name)
+	trackGLObject(kGLBuffer, -1)
+}
+
+func createVertexArrayObject() (name uint32) {
+	gl.CreateVertexArrays(1, &name)
+	trackGLObject(kGLVertexArray, 1)
+	return name
+}
+
+func deleteVertexArrayObject(name uint32) {
+	if name == 0 {
+		return
+	}
+	gl.DeleteVertexArrays(1, &name)
+	trackGLObject(kGLVertexArray, -1)
+}
+
+func createFramebufferObject() (name uint32) {
+	gl.CreateFramebuffers(1, &name)
+	trackGLObject(kGLFramebuffer, 1)
+	return name
+}
+
+func deleteFramebufferObject(name uint32) {
+	if name == 0 {
+		return
+	}
+	gl.DeleteFramebuffers(1, &name)
+	trackGLObject(kGLFramebuffer, -1)
+}
+
+func createSamplerObject() (name uint32) {
+	gl.CreateSamplers(1, &name)
+	trackGLObject(kGLSampler, 1)
+	return name
+}
+
+func deleteSamplerObject(name uint32) {
+	if name == 0 {
+		return
+	}
+	gl.DeleteSamplers(1, &name)
+	trackGLObject(kGLSampler, -1)
+}
@@ -0,0 +1,102 @@
+// Package glfwcontext provides contexts backed by a GLFW window.
+package glfwcontext
+
+import (
+	"image"
+	"runtime"
+	"unsafe"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/smithjacobj/glslfilter"
+)
+
+func init() {
+	// GLFW event handling must run on the main OS thread
+	runtime.LockOSThread()
+}
+
+// Context is a GLFW window with a 3.3 core context. GLFW must only be used from the main goroutine.
+type Context struct {
+	width   int
+	height  int
+	title   string
+	visible bool
+	window  *glfw.Window
+}
+
+// New returns a provider for a non-resizable width x height window. Invisible windows are useful
+// when a display is available but the result only needs to be read back.
+func New(width, height int, title string, visible bool) *Context {
+	return &Context{width: width, height: height, title: title, visible: visible}
+}
+
+func (context *Context) Create() (err error) {
+	if err = glfw.Init(); err != nil {
+		return err
+	}
+
+	if context.visible {
+		glfw.WindowHint(glfw.Visible, glfw.True)
+	} else {
+		glfw.WindowHint(glfw.Visible, glfw.False)
+	}
+	glfw.WindowHint(glfw.Resizable, glfw.False)
+	glfw.WindowHint(glfw.ContextVersionMajor, 3)
+	glfw.WindowHint(glfw.ContextVersionMinor, 3)
+	glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
+	glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
+
+	context.window, err = glfw.CreateWindow(context.width, context.height, context.title, nil, nil)
+	if err != nil {
+		glfw.Terminate()
+		return err
+	}
+	return nil
+}
+
+func (context *Context) MakeCurrent() error {
+	context.window.MakeContextCurrent()
+	return nil
+}
+
+func (context *Context) SwapBuffers() error {
+	context.window.SwapBuffers()
+	return nil
+}
+
+func (context *Context) GetProcAddress(name string) unsafe.Pointer {
+	return glfw.GetProcAddress(name)
+}
+
+func (context *Context) Capabilities() glslfilter.ContextCapabilities {
+	capabilities := glslfilter.ContextCapabilities{Visible: context.visible}
+	if context.window != nil {
+		width, height := context.window.GetFramebufferSize()
+		capabilities.FramebufferSize = image.Pt(width, height)
+	}
+	return capabilities
+}
+
+// Window returns the GLFW window, or nil before Create.
+func (context *Context) Window() *glfw.Window {
+	return context.window
+}
+
+// PollEvents processes pending window events.
+func (context *Context) PollEvents() {
+	glfw.PollEvents()
+}
+
+// ShouldClose reports whether the user closed the window.
+func (context *Context) ShouldClose() bool {
+	return context.window.ShouldClose()
+}
+
+func (context *Context) Destroy() {
+	if context.window == nil {
+		return
+	}
+	context.window.Destroy()
+	context.window = nil
+	glfw.Terminate()
+}
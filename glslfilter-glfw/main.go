@@ -2,30 +2,84 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"image"
-	"image/png"
-	_ "image/png"
+	"io"
 	"log"
 	"os"
-	"runtime"
+	"path/filepath"
+	"strings"
 
-	"github.com/go-gl/glfw/v3.3/glfw"
 	"github.com/smithjacobj/glslfilter"
+	"github.com/smithjacobj/glslfilter/eglcontext"
+	"github.com/smithjacobj/glslfilter/glfwcontext"
+	"github.com/smithjacobj/glslfilter/osmesacontext"
 	"github.com/smithjacobj/glslfilter/util"
 )
 
 const AppName = "GLSL Filter"
 
 var definitionFilePath string
+var baseDir string
+var outputPath string
+var outputDepth int
+var outputFormat string
+var outputQuality int
+var outputCompression string
+var headless bool
+var headlessContext string
+var batchInputDir string
+var batchOutputDir string
+var batchTexture string
+var params = paramFlags{}
+var validateOnly bool
 
-func init() {
-	flag.StringVar(&definitionFilePath, "definitionFile", "", "specify a definition file instead of stdin")
-	flag.Parse()
+// paramFlags collects repeated -set name=value flags. Where the definition uses them as paths, they
+// are resolved against the working directory like any other command-line path.
+type paramFlags map[string]string
+
+func (params paramFlags) String() string {
+	return fmt.Sprint(map[string]string(params))
+}
+
+func (params paramFlags) Set(value string) error {
+	separator := strings.IndexByte(value, '=')
+	if separator < 1 {
+		return fmt.Errorf("expected name=value, got %q", value)
+	}
+	params[value[:separator]] = value[separator+1:]
+	return nil
 }
 
 func init() {
-	// GLFW event handling must run on the main OS thread
-	runtime.LockOSThread()
+	flag.StringVar(&definitionFilePath, "definitionFile", "", "specify a definition file instead of stdin")
+	flag.StringVar(&baseDir, "baseDir", "", "directory that relative paths in the definition are resolved against, defaults to the definition file's directory or the working directory for stdin")
+	flag.StringVar(&outputPath, "output", "", "write the result to a file instead of stdout")
+	flag.IntVar(&outputDepth, "depth", 0, "bits per channel of PNG and TIFF output (8|16)")
+	flag.StringVar(&outputFormat, "format", "", "output image format (png|jpeg|tiff|bmp|exr|hdr), inferred from -output if unset")
+	flag.IntVar(&outputQuality, "quality", 0, "JPEG quality (1-100)")
+	flag.StringVar(&outputCompression, "compression", "", "TIFF (none|deflate) or EXR (none|rle|zips|zip) compression")
+	flag.BoolVar(&headless, "headless", false, "render without a window or display server")
+	flag.StringVar(&headlessContext, "headlessContext", "egl", "context used in headless mode (egl|osmesa)")
+	flag.StringVar(&batchInputDir, "batch", "", "process every image in a directory, writing the results to -out")
+	flag.StringVar(&batchOutputDir, "out", "", "output directory for -batch")
+	flag.Var(params, "set", "override a definition param as name=value, may be repeated; values used as paths are relative to the working directory")
+	flag.StringVar(&batchTexture, "batchTexture", "", "texture replaced by each -batch image, defaults to the first texture of the first stage that has one")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags]\n       %s validate [flags] [definition file]\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	// "validate" checks the definition and exits without rendering
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		validateOnly = true
+		util.Invariant(flag.CommandLine.Parse(os.Args[2:]))
+		if flag.NArg() > 0 {
+			definitionFilePath = flag.Arg(0)
+		}
+		return
+	}
+	flag.Parse()
 }
 
 var showResult bool = true
@@ -34,96 +88,282 @@ func init() {
 	fileInfo, err := os.Stdout.Stat()
 	util.Invariant(err)
 	// if we're just in a terminal and not piped, show the window
-	showResult = fileInfo.Mode()&os.ModeCharDevice != 0
+	showResult = !headless && len(batchInputDir) == 0 && fileInfo.Mode()&os.ModeCharDevice != 0
 }
 
 func main() {
-	perfTimer := util.NewPerfTimer()
-
-	util.Invariant(glfw.Init())
-	defer glfw.Terminate()
-
-	if showResult {
-		glfw.WindowHint(glfw.Visible, glfw.True)
-	} else {
-		glfw.WindowHint(glfw.Visible, glfw.False)
+	if validateOnly {
+		os.Exit(validate())
 	}
-	glfw.WindowHint(glfw.Resizable, glfw.False)
-	glfw.WindowHint(glfw.ContextVersionMajor, 3)
-	glfw.WindowHint(glfw.ContextVersionMinor, 3)
-	glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
-	glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
 
-	file := os.Stdin
+	perfTimer := util.NewPerfTimer()
+
+	var definition glslfilter.Definition
+	var err error
+	loadOptions := glslfilter.LoadOptions{Params: params, ParamsDir: ".", Dir: baseDir}
 	if len(definitionFilePath) > 0 {
-		var err error
-		file, err = os.Open(definitionFilePath)
-		util.Invariant(err)
+		definition, err = glslfilter.LoadDefinitionFromPath(definitionFilePath, loadOptions)
+	} else {
+		definition, err = glslfilter.LoadDefinitionFromFileWithOptions(os.Stdin, loadOptions)
 	}
-	definition, err := glslfilter.LoadDefinitionFromFile(file)
-	util.Invariant(err)
-
-	window, err := glfw.CreateWindow(definition.Render.Width, definition.Render.Height, AppName, nil, nil)
 	util.Invariant(err)
-	window.MakeContextCurrent()
 
-	engine, err := glslfilter.NewEngine(image.Rect(0, 0, definition.Render.Width, definition.Render.Height), true, showResult)
-	util.Invariant(err)
+	output := definition.Output
+	if len(outputPath) > 0 {
+		output.Path = outputPath
+	}
+	if len(outputFormat) > 0 {
+		output.Format = outputFormat
+	}
+	if outputDepth != 0 {
+		output.Depth = outputDepth
+	}
+	if outputQuality != 0 {
+		output.Quality = outputQuality
+	}
+	if len(outputCompression) > 0 {
+		output.Compression = outputCompression
+	}
+	// bad output options fail before anything is rendered or a file is created
+	util.Invariant(output.Validate())
 
-	stages := []*glslfilter.FilterStage{}
-	for _, stageDefinition := range definition.Stages {
-		fragmentShaderSource, err := glslfilter.LoadFragmentShader(stageDefinition.FragmentShaderPath)
+	var batchInputPaths, batchOutputPaths []string
+	var textureOverrides map[string]image.Image
+	if len(batchInputDir) > 0 {
+		batchInputPaths, err = listBatchInputs(batchInputDir)
 		util.Invariant(err)
-
-		textures := []glslfilter.Texture{}
-		for _, textureDefinition := range stageDefinition.Textures {
-			textureRGBA, err := glslfilter.LoadTextureData(textureDefinition.Path)
+		batchOutput := output
+		batchOutput.Path = ""
+		format, err := batchOutput.ResolveFormat()
+		util.Invariant(err)
+		batchOutputPaths, err = outputPathsForBatch(batchInputPaths, format)
+		util.Invariant(err)
+		if len(batchTexture) == 0 {
+			batchTexture, err = firstTextureName(definition)
 			util.Invariant(err)
-			textures = append(
-				textures,
-				glslfilter.Texture{
-					Data:        textureRGBA,
-					BindingName: textureDefinition.Name,
-					Filter:      int32(textureDefinition.Filter),
-				})
 		}
 
-		stage, err := glslfilter.NewFilterStage(fragmentShaderSource, textures, stageDefinition.Uniforms)
+		// the first input is uploaded when the stages are created
+		textureData, err := glslfilter.LoadTextureData(batchInputPaths[0])
 		util.Invariant(err)
-
-		stages = append(stages, stage)
+		textureOverrides = map[string]image.Image{batchTexture: textureData}
 	}
 
-	err = engine.Init(stages)
+	var window *glfwcontext.Context
+	var windowRenderSize image.Point
+	engine, err := glslfilter.BuildPipeline(
+		definition,
+		glslfilter.WithContextFunc(func(renderSize image.Point) (glslfilter.ContextProvider, error) {
+			if headless {
+				return createHeadlessContext(renderSize.X, renderSize.Y)
+			}
+			window = glfwcontext.New(renderSize.X, renderSize.Y, AppName, showResult)
+			windowRenderSize = renderSize
+			return window, nil
+		}),
+		glslfilter.WithDebug(true),
+		glslfilter.WithTextures(textureOverrides))
 	util.Invariant(err)
+	defer engine.Close()
 
 	perfTimer.LogSplit("init")
 
+	if len(batchInputPaths) > 0 {
+		runBatch(engine, definition, output, batchInputPaths, batchOutputPaths, perfTimer)
+		return
+	}
+
 	if err := engine.Render(); err != nil {
 		util.Invariant(err)
 	}
-	window.SwapBuffers()
+	util.Invariant(engine.SwapBuffers())
 
 	perfTimer.LogSplit("render")
 
-	if !showResult {
-		wait := make(chan bool)
-		imageData := engine.GetLastRenderImage()
+	// a result with an output path is written even when it's also shown
+	if !showResult || len(output.Path) > 0 {
+		writer := os.Stdout
+		if len(output.Path) > 0 {
+			writer, err = os.Create(output.Path)
+			util.Invariant(err)
+		}
+
+		imageData, err := engine.OutputImage(output)
+		util.Invariant(err)
 
-		log.Println("writing out PNG")
+		wait := make(chan bool)
+		log.Printf("writing out %s\n", writer.Name())
 		go func() {
-			err := png.Encode(os.Stdout, imageData)
+			err := glslfilter.EncodeOutput(writer, imageData, output)
 			util.Invariant(err)
+			if writer != os.Stdout {
+				util.Invariant(writer.Close())
+			}
 
 			wait <- true
 		}()
 		defer func() {
 			<-wait
-			perfTimer.LogSplit("PNG written")
+			perfTimer.LogSplit("image written")
 		}()
-	} else {
+	}
+
+	if showResult {
+		// animated pipelines are rendered again for every frame the window shows
+		animated := engine.Animated()
 		for !window.ShouldClose() {
-			glfw.PollEvents()
+			window.PollEvents()
+			if animated {
+				// the cursor position is in window coordinates, from the top left
+				cursorX, cursorY := window.Window().GetCursorPos()
+				windowWidth, windowHeight := window.Window().GetSize()
+				if windowWidth > 0 && windowHeight > 0 {
+					engine.SetMouse(
+						cursorX*float64(windowRenderSize.X)/float64(windowWidth),
+						(float64(windowHeight)-cursorY)*float64(windowRenderSize.Y)/float64(windowHeight))
+				}
+				util.Invariant(engine.Render())
+				util.Invariant(engine.SwapBuffers())
+			}
 		}
 	}
 }
+
+// validate prints every problem found in the definition as file:line:column: message and returns
+// the exit status.
+func validate() int {
+	name := "<stdin>"
+	loadOptions := glslfilter.LoadOptions{Params: params, ParamsDir: ".", Dir: baseDir}
+	var definitionString []byte
+	var err error
+	if len(definitionFilePath) > 0 {
+		name = definitionFilePath
+		definitionString, err = os.ReadFile(definitionFilePath)
+		if len(loadOptions.Dir) == 0 {
+			loadOptions.Dir = filepath.Dir(definitionFilePath)
+		}
+	} else {
+		definitionString, err = io.ReadAll(os.Stdin)
+	}
+	util.Invariant(err)
+
+	problems := glslfilter.ValidateDefinition(definitionString, loadOptions)
+	for _, problem := range problems {
+		switch {
+		case problem.Line == 0:
+			fmt.Printf("%s: %v\n", name, problem.Err)
+		case problem.Column == 0:
+			fmt.Printf("%s:%d: %v\n", name, problem.Line, problem.Err)
+		default:
+			fmt.Printf("%s:%d:%d: %v\n", name, problem.Line, problem.Column, problem.Err)
+		}
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	return 0
+}
+
+var kBatchInputExtensions = map[string]bool{
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".bmp": true,
+	".tif": true, ".tiff": true, ".webp": true, ".exr": true, ".hdr": true,
+}
+
+func listBatchInputs(dir string) (paths []string, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && kBatchInputExtensions[strings.ToLower(filepath.Ext(entry.Name()))] {
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no images found in %s", dir)
+	}
+	return paths, nil
+}
+
+func firstTextureName(definition glslfilter.Definition) (string, error) {
+	for _, stageDefinition := range definition.Stages {
+		if len(stageDefinition.Textures) > 0 {
+			return stageDefinition.Textures[0].Name, nil
+		}
+	}
+	return "", fmt.Errorf("-batch needs a stage with a texture to replace")
+}
+
+// outputPathsForBatch returns the path each input's result is written to: its name with the
+// extension of format, in the output directory. Inputs whose results would have the same name or
+// overwrite an input are an error.
+func outputPathsForBatch(inputPaths []string, format string) (outputPaths []string, err error) {
+	inputs := make(map[string]string)
+	for _, inputPath := range inputPaths {
+		absolute, err := filepath.Abs(inputPath)
+		if err != nil {
+			return nil, err
+		}
+		inputs[absolute] = inputPath
+	}
+
+	outputs := make(map[string]string)
+	for _, inputPath := range inputPaths {
+		name := strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath)) + "." + format
+		outputPath := filepath.Join(batchOutputDir, name)
+		absolute, err := filepath.Abs(outputPath)
+		if err != nil {
+			return nil, err
+		}
+		if other, exists := outputs[absolute]; exists {
+			return nil, fmt.Errorf("the results of %s and %s would both be written to %s", other, inputPath, outputPath)
+		}
+		if input, exists := inputs[absolute]; exists {
+			return nil, fmt.Errorf("the result of %s would overwrite the input %s", inputPath, input)
+		}
+		outputs[absolute] = inputPath
+		outputPaths = append(outputPaths, outputPath)
+	}
+	return outputPaths, nil
+}
+
+// runBatch renders every input through the already compiled stages. If the render size comes from
+// the batch texture the engine resolves it again for every input, otherwise it keeps its ratio to
+// the size of the batch texture.
+func runBatch(engine *glslfilter.Engine, definition glslfilter.Definition, output glslfilter.OutputDefinition, inputPaths []string, outputPaths []string, perfTimer *util.PerfTimer) {
+	if definition.Render.Size.FromTexture != batchTexture {
+		util.Invariant(engine.SetSizeSource(batchTexture))
+	}
+	output.Path = ""
+	util.Invariant(os.MkdirAll(batchOutputDir, 0755))
+
+	for i, inputPath := range inputPaths {
+		// the first input was uploaded when the stages were created
+		if i > 0 {
+			textureData, err := glslfilter.LoadTextureData(inputPath)
+			util.Invariant(err)
+			util.Invariant(engine.SetInputs(map[string]image.Image{batchTexture: textureData}))
+		}
+		util.Invariant(engine.Render())
+
+		imageData, err := engine.OutputImage(output)
+		util.Invariant(err)
+
+		writer, err := os.Create(outputPaths[i])
+		util.Invariant(err)
+		util.Invariant(glslfilter.EncodeOutput(writer, imageData, output))
+		util.Invariant(writer.Close())
+
+		perfTimer.LogSplit(filepath.Base(outputPaths[i]))
+	}
+}
+
+func createHeadlessContext(width, height int) (glslfilter.ContextProvider, error) {
+	switch headlessContext {
+	case "egl":
+		return eglcontext.New(), nil
+	case "osmesa":
+		return osmesacontext.New(width, height), nil
+	}
+	return nil, fmt.Errorf("unsupported headless context: %s", headlessContext)
+}
@@ -5,6 +5,6 @@ go 1.17
 require (
 	github.com/go-gl/gl v0.0.0-20211210172815-726fda9656d6
 	github.com/go-gl/glfw/v3.3/glfw v0.0.0-20211213063430-748e38ca8aec
-	github.com/go-yaml/yaml v2.1.0+incompatible
-	gopkg.in/yaml.v2 v2.4.0
+	golang.org/x/image v0.5.0
+	gopkg.in/yaml.v3 v3.0.1
 )
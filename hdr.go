@@ -0,0 +1,299 @@
+package glslfilter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+)
+
+const kHDRMagic = "#?RADIANCE"
+const kHDRAlternateMagic = "#?RGBE"
+const kHDRFormat = "32-bit_rle_rgbe"
+
+func init() {
+	image.RegisterFormat("hdr", kHDRMagic, DecodeHDR, DecodeHDRConfig)
+	image.RegisterFormat("hdr", kHDRAlternateMagic, DecodeHDR, DecodeHDRConfig)
+}
+
+// DecodeHDR decodes a Radiance RGBE image into a *FloatImage with an opaque alpha channel.
+func DecodeHDR(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	input := bytes.NewReader(data)
+	reader := bufio.NewReader(input)
+	width, height, err := readHDRHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	// every scanline starts with at least one pixel
+	if remaining := input.Len() + reader.Buffered(); height*4 > remaining {
+		return nil, fmt.Errorf("hdr: truncated pixel data")
+	}
+
+	// rows are allocated as they're decoded, so that a small file declaring a large size can't
+	// allocate more than the pixel data it holds
+	img := &FloatImage{Stride: 4 * width, Rect: image.Rect(0, 0, width, height)}
+	scanline := make([]byte, 4*width)
+	for y := 0; y < height; y++ {
+		if err = readHDRScanline(reader, scanline); err != nil {
+			return nil, err
+		}
+		img.Pix = append(img.Pix, make([]float32, img.Stride)...)
+		for x := 0; x < width; x++ {
+			r, g, b := rgbeToFloat(scanline[x*4 : x*4+4])
+			img.SetFloat(x, y, r, g, b, 1)
+		}
+	}
+	return img, nil
+}
+
+func DecodeHDRConfig(r io.Reader) (image.Config, error) {
+	width, height, err := readHDRHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: color.RGBA64Model, Width: width, Height: height}, nil
+}
+
+func readHDRHeader(reader *bufio.Reader) (width, height int, err error) {
+	magic, err := reader.ReadString('\n')
+	if err != nil {
+		return 0, 0, err
+	}
+	magic = strings.TrimSpace(magic)
+	if magic != kHDRMagic && magic != kHDRAlternateMagic {
+		return 0, 0, fmt.Errorf("hdr: not a Radiance HDR file")
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return 0, 0, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "FORMAT=") && line != "FORMAT="+kHDRFormat {
+			return 0, 0, fmt.Errorf("hdr: unsupported %s", line)
+		}
+	}
+
+	resolution, err := reader.ReadString('\n')
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err = fmt.Sscanf(resolution, "-Y %d +X %d", &height, &width); err != nil {
+		return 0, 0, fmt.Errorf("hdr: unsupported resolution line %q", strings.TrimSpace(resolution))
+	}
+	if width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("hdr: invalid size %dx%d", width, height)
+	}
+	if width > kMaxDecodedPixels || height > kMaxDecodedPixels || width*height > kMaxDecodedPixels {
+		return 0, 0, fmt.Errorf("hdr: size %dx%d is too large", width, height)
+	}
+	return width, height, nil
+}
+
+func readHDRScanline(reader *bufio.Reader, scanline []byte) error {
+	width := len(scanline) / 4
+
+	start := make([]byte, 4)
+	if _, err := io.ReadFull(reader, start); err != nil {
+		return err
+	}
+
+	if width < 8 || width > 0x7fff || start[0] != 2 || start[1] != 2 || start[2]&0x80 != 0 {
+		return readFlatHDRScanline(reader, scanline, start)
+	}
+	if int(start[2])<<8|int(start[3]) != width {
+		return fmt.Errorf("hdr: scanline width mismatch")
+	}
+
+	// each component is run-length encoded separately
+	for component := 0; component < 4; component++ {
+		for x := 0; x < width; {
+			count, err := reader.ReadByte()
+			if err != nil {
+				return err
+			}
+			if count > 128 {
+				run := int(count) - 128
+				if x+run > width {
+					return fmt.Errorf("hdr: invalid run length")
+				}
+				value, err := reader.ReadByte()
+				if err != nil {
+					return err
+				}
+				for ; run > 0; run-- {
+					scanline[x*4+component] = value
+					x++
+				}
+			} else {
+				if count == 0 || x+int(count) > width {
+					return fmt.Errorf("hdr: invalid run length")
+				}
+				for ; count > 0; count-- {
+					value, err := reader.ReadByte()
+					if err != nil {
+						return err
+					}
+					scanline[x*4+component] = value
+					x++
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// readFlatHDRScanline reads uncompressed pixels, including the original Radiance run-length
+// encoding where a 1,1,1 pixel repeats the previous one.
+func readFlatHDRScanline(reader *bufio.Reader, scanline []byte, first []byte) error {
+	width := len(scanline) / 4
+	pixel := first
+	shift := uint(0)
+	x := 0
+	for {
+		if pixel[0] == 1 && pixel[1] == 1 && pixel[2] == 1 {
+			if x == 0 {
+				return fmt.Errorf("hdr: run at start of scanline")
+			}
+			run := int(pixel[3]) << shift
+			if x+run > width {
+				return fmt.Errorf("hdr: invalid run length")
+			}
+			for ; run > 0; run-- {
+				copy(scanline[x*4:x*4+4], scanline[x*4-4:x*4])
+				x++
+			}
+			shift += 8
+		} else {
+			copy(scanline[x*4:x*4+4], pixel)
+			x++
+			shift = 0
+		}
+
+		if x >= width {
+			return nil
+		}
+		if _, err := io.ReadFull(reader, pixel); err != nil {
+			return err
+		}
+	}
+}
+
+func rgbeToFloat(rgbe []byte) (r, g, b float32) {
+	if rgbe[3] == 0 {
+		return 0, 0, 0
+	}
+	scale := float32(math.Ldexp(1, int(rgbe[3])-(128+8)))
+	return (float32(rgbe[0]) + 0.5) * scale, (float32(rgbe[1]) + 0.5) * scale, (float32(rgbe[2]) + 0.5) * scale
+}
+
+func floatToRGBE(r, g, b float32) [4]byte {
+	v := r
+	if g > v {
+		v = g
+	}
+	if b > v {
+		v = b
+	}
+	if v < 1e-32 {
+		return [4]byte{}
+	}
+
+	mantissa, exponent := math.Frexp(float64(v))
+	scale := float32(mantissa * 256 / float64(v))
+	return [4]byte{
+		byte(math.Max(0, float64(r*scale))),
+		byte(math.Max(0, float64(g*scale))),
+		byte(math.Max(0, float64(b*scale))),
+		byte(exponent + 128),
+	}
+}
+
+// EncodeHDR writes m as a run-length encoded Radiance RGBE image. Alpha is discarded.
+func EncodeHDR(w io.Writer, m image.Image) error {
+	bounds := m.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	writer := bufio.NewWriter(w)
+	fmt.Fprintf(writer, "%s\nFORMAT=%s\n\n-Y %d +X %d\n", kHDRMagic, kHDRFormat, height, width)
+
+	scanline := make([]byte, width*4)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			rgbe := floatToRGBE(
+				floatComponent(m, bounds.Min.X+x, bounds.Min.Y+y, 0),
+				floatComponent(m, bounds.Min.X+x, bounds.Min.Y+y, 1),
+				floatComponent(m, bounds.Min.X+x, bounds.Min.Y+y, 2),
+			)
+			copy(scanline[x*4:], rgbe[:])
+		}
+
+		if width < 8 || width > 0x7fff {
+			writer.Write(scanline)
+			continue
+		}
+
+		writer.Write([]byte{2, 2, byte(width >> 8), byte(width)})
+		component := make([]byte, width)
+		for c := 0; c < 4; c++ {
+			for x := range component {
+				component[x] = scanline[x*4+c]
+			}
+			writeHDRRuns(writer, component)
+		}
+	}
+	return writer.Flush()
+}
+
+func writeHDRRuns(writer *bufio.Writer, data []byte) {
+	const minRunLength = 4
+	const maxLength = 127
+
+	for i := 0; i < len(data); {
+		// find the next run long enough to be worth encoding
+		runStart := i
+		runLength := 0
+		for runStart < len(data) {
+			runLength = 1
+			for runStart+runLength < len(data) && runLength < maxLength && data[runStart+runLength] == data[runStart] {
+				runLength++
+			}
+			if runLength >= minRunLength {
+				break
+			}
+			runStart += runLength
+		}
+		if runLength < minRunLength {
+			runStart = len(data)
+		}
+
+		for i < runStart {
+			n := runStart - i
+			if n > maxLength+1 {
+				n = maxLength + 1
+			}
+			writer.WriteByte(byte(n))
+			writer.Write(data[i : i+n])
+			i += n
+		}
+
+		if runStart < len(data) {
+			writer.WriteByte(byte(128 + runLength))
+			writer.WriteByte(data[runStart])
+			i = runStart + runLength
+		}
+	}
+}
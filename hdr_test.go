@@ -0,0 +1,81 @@
+package glslfilter
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestHDRRoundTrip(t *testing.T) {
+	// widths below 8 are written flat, wider ones run-length encoded per component
+	for _, width := range []int{5, 8, 300} {
+		want := NewFloatImage(image.Rect(0, 0, width, 3))
+		for y := 0; y < 3; y++ {
+			for x := 0; x < width; x++ {
+				r := float32(x) / 7
+				if x > width/2 {
+					r = 1000
+				}
+				want.SetFloat(x, y, r, float32(y)/3, float32(x%5)*0.01, 1)
+			}
+		}
+
+		var buffer bytes.Buffer
+		if err := EncodeHDR(&buffer, want); err != nil {
+			t.Fatalf("width %d: encode: %v", width, err)
+		}
+		m, format, err := image.Decode(&buffer)
+		if err != nil {
+			t.Fatalf("width %d: decode: %v", width, err)
+		}
+		if format != "hdr" || m.Bounds() != want.Bounds() {
+			t.Fatalf("width %d: decoded %s %v", width, format, m.Bounds())
+		}
+
+		got := m.(*FloatImage)
+		for y := 0; y < 3; y++ {
+			for x := 0; x < width; x++ {
+				wantR, wantG, wantB, _ := want.FloatAt(x, y)
+				r, g, b, a := got.FloatAt(x, y)
+				// RGBE keeps 8 bits of mantissa relative to the largest component
+				tolerance := float64(maxFloat32(wantR, wantG, wantB)) / 128
+				if math.Abs(float64(r-wantR)) > tolerance || math.Abs(float64(g-wantG)) > tolerance || math.Abs(float64(b-wantB)) > tolerance || a != 1 {
+					t.Fatalf("width %d: (%d, %d) = %v %v %v %v, want %v %v %v 1", width, x, y, r, g, b, a, wantR, wantG, wantB)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeHDRRejectsInvalidFiles(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"P6\n", "not a Radiance HDR file"},
+		{"#?RADIANCE\nFORMAT=32-bit_rle_xyze\n\n-Y 1 +X 1\n", "unsupported FORMAT"},
+		{"#?RADIANCE\n\n+Y 1 +X 1\n", "unsupported resolution line"},
+		{"#?RADIANCE\n\n-Y 0 +X 1\n", "invalid size"},
+		{"#?RADIANCE\n\n-Y 100000 +X 100000\n", "too large"},
+		{"#?RADIANCE\n\n-Y 1 +X 8\n\x02\x02\x00\x09", "width mismatch"},
+		{"#?RADIANCE\n\n-Y 1 +X 8\n\x02\x02\x00\x08\x8a\x00", "invalid run length"},
+		{"#?RADIANCE\n\n-Y 1 +X 2\n\x01\x01\x01\x01", "run at start of scanline"},
+		{"#?RADIANCE\n\n-Y 8192 +X 8192\n\x02\x02\x20\x00", "truncated pixel data"},
+	}
+	for _, test := range tests {
+		if _, err := DecodeHDR(strings.NewReader(test.data)); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%q: got error %v, want %q", test.data, err, test.want)
+		}
+	}
+}
+
+func maxFloat32(values ...float32) (max float32) {
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
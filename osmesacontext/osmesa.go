@@ -0,0 +1,98 @@
+//go:build osmesa
+// +build osmesa
+
+// Package osmesacontext creates OpenGL contexts with Mesa's off-screen renderer, which renders on
+// the CPU into a buffer in memory. It needs libOSMesa and is only built with the osmesa build tag.
+package osmesacontext
+
+/*
+#cgo LDFLAGS: -lOSMesa
+#include <stdlib.h>
+#include <GL/osmesa.h>
+
+static OSMesaContext createContext() {
+	const int attributes[] = {
+		OSMESA_FORMAT, OSMESA_RGBA,
+		OSMESA_DEPTH_BITS, 0,
+		OSMESA_PROFILE, OSMESA_CORE_PROFILE,
+		OSMESA_CONTEXT_MAJOR_VERSION, 3,
+		OSMESA_CONTEXT_MINOR_VERSION, 3,
+		0,
+	};
+	return OSMesaCreateContextAttribs(attributes, NULL);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"image"
+	"unsafe"
+
+	"github.com/smithjacobj/glslfilter"
+)
+
+// Context is an OSMesa context rendering into a width x height RGBA buffer.
+type Context struct {
+	width   int
+	height  int
+	context C.OSMesaContext
+	buffer  unsafe.Pointer
+}
+
+// New returns a provider for a context whose default framebuffer is a width x height buffer in
+// memory.
+func New(width, height int) *Context {
+	return &Context{width: width, height: height}
+}
+
+func (context *Context) Create() error {
+	if context.width <= 0 || context.height <= 0 {
+		return fmt.Errorf("osmesa: invalid buffer size %dx%d", context.width, context.height)
+	}
+
+	context.context = C.createContext()
+	if context.context == nil {
+		return fmt.Errorf("osmesa: failed to create a 3.3 core context")
+	}
+	// OSMesa keeps a pointer to the buffer, so it can't live in Go memory
+	context.buffer = C.calloc(C.size_t(context.width*context.height), 4)
+	if context.buffer == nil {
+		context.Destroy()
+		return fmt.Errorf("osmesa: failed to allocate the color buffer")
+	}
+	return nil
+}
+
+func (context *Context) MakeCurrent() error {
+	if C.OSMesaMakeCurrent(context.context, context.buffer, C.GL_UNSIGNED_BYTE, C.GLsizei(context.width), C.GLsizei(context.height)) == C.GL_FALSE {
+		return fmt.Errorf("osmesa: OSMesaMakeCurrent failed")
+	}
+	return nil
+}
+
+// SwapBuffers is a no-op, OSMesa renders directly into its buffer.
+func (context *Context) SwapBuffers() error {
+	return nil
+}
+
+func (context *Context) GetProcAddress(name string) unsafe.Pointer {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	return unsafe.Pointer(C.OSMesaGetProcAddress(cName))
+}
+
+func (context *Context) Capabilities() glslfilter.ContextCapabilities {
+	return glslfilter.ContextCapabilities{FramebufferSize: image.Pt(context.width, context.height)}
+}
+
+func (context *Context) Destroy() {
+	if context.context != nil {
+		C.OSMesaDestroyContext(context.context)
+		context.context = nil
+	}
+	if context.buffer != nil {
+		C.free(context.buffer)
+		context.buffer = nil
+	}
+}
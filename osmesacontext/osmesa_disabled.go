@@ -0,0 +1,40 @@
+//go:build !osmesa
+// +build !osmesa
+
+package osmesacontext
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/smithjacobj/glslfilter"
+)
+
+// Context is only available when built with the osmesa build tag.
+type Context struct{}
+
+func New(width, height int) *Context {
+	return &Context{}
+}
+
+func (context *Context) Create() error {
+	return fmt.Errorf("osmesa: support not built in, rebuild with -tags osmesa")
+}
+
+func (context *Context) MakeCurrent() error {
+	return fmt.Errorf("osmesa: support not built in, rebuild with -tags osmesa")
+}
+
+func (context *Context) SwapBuffers() error {
+	return nil
+}
+
+func (context *Context) GetProcAddress(name string) unsafe.Pointer {
+	return nil
+}
+
+func (context *Context) Capabilities() glslfilter.ContextCapabilities {
+	return glslfilter.ContextCapabilities{}
+}
+
+func (context *Context) Destroy() {}
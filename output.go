@@ -0,0 +1,189 @@
+package glslfilter
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// OutputDefinition describes how the final result is written out. Format is inferred from the
+// extension of Path when it isn't set and defaults to PNG.
+type OutputDefinition struct {
+	Path        string
+	Format      string
+	Depth       int
+	Quality     int
+	Compression string
+}
+
+var kOutputFormatExtensions = map[string]string{
+	".png":  "png",
+	".jpg":  "jpeg",
+	".jpeg": "jpeg",
+	".tif":  "tiff",
+	".tiff": "tiff",
+	".bmp":  "bmp",
+	".exr":  "exr",
+	".hdr":  "hdr",
+}
+
+// ResolveFormat returns the normalized output format name.
+func (output OutputDefinition) ResolveFormat() (format string, err error) {
+	format = strings.ToLower(output.Format)
+	switch format {
+	case "":
+		if output.Path == "" {
+			return "png", nil
+		}
+		var exists bool
+		format, exists = kOutputFormatExtensions[strings.ToLower(filepath.Ext(output.Path))]
+		if !exists {
+			return "", fmt.Errorf("can't infer output format from %q", output.Path)
+		}
+		return format, nil
+	case "jpg":
+		return "jpeg", nil
+	case "tif":
+		return "tiff", nil
+	case "png", "jpeg", "tiff", "bmp", "exr", "hdr":
+		return format, nil
+	}
+	return "", fmt.Errorf("unsupported output format: %s", output.Format)
+}
+
+// Validate checks every option of output, so that a bad one is reported before anything is
+// rendered or written.
+func (output OutputDefinition) Validate() error {
+	format, err := output.ResolveFormat()
+	if err != nil {
+		return err
+	}
+	if _, err = output.depth(); err != nil {
+		return err
+	}
+	switch format {
+	case "jpeg":
+		_, err = output.jpegOptions()
+	case "tiff":
+		_, err = output.tiffOptions()
+	case "exr":
+		_, err = output.exrOptions()
+	}
+	return err
+}
+
+func (output OutputDefinition) depth() (depth int, err error) {
+	switch output.Depth {
+	case 0, 8:
+		return 8, nil
+	case 16:
+		return 16, nil
+	}
+	return 0, fmt.Errorf("unsupported output depth: %d", output.Depth)
+}
+
+// OutputImage reads back the final result at the precision the output format can store.
+func (engine *Engine) OutputImage(output OutputDefinition) (image.Image, error) {
+	format, err := output.ResolveFormat()
+	if err != nil {
+		return nil, err
+	}
+	depth, err := output.depth()
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case "exr", "hdr":
+		return engine.GetLastRenderFloat(), nil
+	case "png", "tiff":
+		if depth == 16 {
+			return engine.GetLastRenderImage64(), nil
+		}
+	}
+	return engine.GetLastRenderImage(), nil
+}
+
+// EncodeOutput writes m to w using the encoder and options selected by output.
+func EncodeOutput(w io.Writer, m image.Image, output OutputDefinition) error {
+	format, err := output.ResolveFormat()
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "png":
+		return png.Encode(w, m)
+	case "jpeg":
+		options, err := output.jpegOptions()
+		if err != nil {
+			return err
+		}
+		return jpeg.Encode(w, m, options)
+	case "tiff":
+		options, err := output.tiffOptions()
+		if err != nil {
+			return err
+		}
+		return tiff.Encode(w, m, options)
+	case "bmp":
+		return bmp.Encode(w, m)
+	case "exr":
+		options, err := output.exrOptions()
+		if err != nil {
+			return err
+		}
+		return EncodeEXR(w, m, options)
+	case "hdr":
+		return EncodeHDR(w, m)
+	}
+	return fmt.Errorf("unsupported output format: %s", format)
+}
+
+func (output OutputDefinition) jpegOptions() (options *jpeg.Options, err error) {
+	quality := output.Quality
+	if quality == 0 {
+		quality = jpeg.DefaultQuality
+	}
+	if quality < 1 || quality > 100 {
+		return nil, fmt.Errorf("jpeg quality must be between 1 and 100, got %d", quality)
+	}
+	return &jpeg.Options{Quality: quality}, nil
+}
+
+func (output OutputDefinition) tiffOptions() (options *tiff.Options, err error) {
+	options = &tiff.Options{}
+	switch strings.ToLower(output.Compression) {
+	case "", "none":
+		options.Compression = tiff.Uncompressed
+	case "deflate":
+		options.Compression = tiff.Deflate
+	default:
+		return nil, fmt.Errorf("unsupported tiff compression: %s", output.Compression)
+	}
+	return options, nil
+}
+
+func (output OutputDefinition) exrOptions() (options *EXROptions, err error) {
+	options = &EXROptions{}
+	switch strings.ToLower(output.Compression) {
+	case "", "zip":
+		options.Compression = EXRZIPCompression
+	case "none":
+		options.Compression = EXRNoCompression
+	case "rle":
+		options.Compression = EXRRLECompression
+	case "zips":
+		options.Compression = EXRZIPSCompression
+	default:
+		return nil, fmt.Errorf("unsupported exr compression: %s", output.Compression)
+	}
+	return options, nil
+}
@@ -0,0 +1,363 @@
+package glslfilter
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+const kDefinitionDirParamName = "definitionDir"
+
+// definitionParams resolves the names used in ${} expressions of a definition.
+type definitionParams struct {
+	values     map[string]string
+	imageSizes map[string]image.Point
+	files      definitionFiles
+	// overrides are the params given in LoadOptions, which are resolved against overridesDir where
+	// they're used as paths unless it's empty.
+	overrides    map[string]string
+	overridesDir string
+	// pathNodes are the definition's shader, texture and include paths, and inPath is set while
+	// one of them is interpolated.
+	pathNodes map[*yaml.Node]bool
+	inPath    bool
+}
+
+// interpolate replaces every ${expression} in value. A value consisting of a single numeric
+// expression is returned as an int or float64 so it can fill numeric fields; everything else is
+// returned as a string. $$ is a literal $.
+func (params *definitionParams) interpolate(value string) (interface{}, error) {
+	var result strings.Builder
+	var single interface{}
+	parts := 0
+
+	for i := 0; i < len(value); {
+		if strings.HasPrefix(value[i:], "$$") {
+			result.WriteByte('$')
+			i += 2
+			parts++
+			continue
+		}
+		if !strings.HasPrefix(value[i:], "${") {
+			result.WriteByte(value[i])
+			i++
+			parts++
+			continue
+		}
+
+		end := strings.IndexByte(value[i:], '}')
+		if end == -1 {
+			return nil, fmt.Errorf("unterminated expression in %q", value)
+		}
+		evaluated, err := params.evaluate(value[i+2 : i+end])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", value[i:i+end+1], err)
+		}
+		result.WriteString(formatParamValue(evaluated))
+		single = evaluated
+		parts++
+		i += end + 1
+	}
+
+	if number, ok := single.(float64); ok && parts == 1 {
+		if number == math.Trunc(number) && math.Abs(number) < math.MaxInt32 {
+			return int(number), nil
+		}
+		return number, nil
+	}
+	return result.String(), nil
+}
+
+func formatParamValue(value interface{}) string {
+	if number, ok := value.(float64); ok {
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+	return value.(string)
+}
+
+func (params *definitionParams) evaluate(expression string) (interface{}, error) {
+	parser := expressionParser{params: params, tokens: tokenizeExpression(expression)}
+	value, err := parser.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	if parser.position < len(parser.tokens) {
+		return nil, fmt.Errorf("unexpected %q", parser.tokens[parser.position])
+	}
+	return value, nil
+}
+
+// lookup returns a param as a number if it looks like one. name.width and name.height are the
+// dimensions of the image file at the path held by the param.
+func (params *definitionParams) lookup(name string) (interface{}, error) {
+	if dot := strings.LastIndexByte(name, '.'); dot != -1 {
+		property := name[dot+1:]
+		if property == "width" || property == "height" {
+			size, err := params.imageSize(name[:dot])
+			if err != nil {
+				return nil, err
+			}
+			if property == "width" {
+				return float64(size.X), nil
+			}
+			return float64(size.Y), nil
+		}
+	}
+
+	value, exists := params.values[name]
+	if !exists {
+		return nil, fmt.Errorf("undefined parameter %q", name)
+	}
+	if _, overridden := params.overrides[name]; overridden && params.inPath {
+		return params.overridePath(name), nil
+	}
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		return number, nil
+	}
+	return value, nil
+}
+
+func (params *definitionParams) imageSize(name string) (size image.Point, err error) {
+	if size, exists := params.imageSizes[name]; exists {
+		return size, nil
+	}
+	if _, exists := params.values[name]; !exists {
+		return size, fmt.Errorf("undefined parameter %q", name)
+	}
+
+	path := params.files.resolve(params.overridePath(name))
+	imageFile, err := params.files.open(path)
+	if err != nil {
+		return size, err
+	}
+	defer imageFile.Close()
+
+	config, _, err := image.DecodeConfig(imageFile)
+	if err != nil {
+		return size, fmt.Errorf("%s: %v", path, err)
+	}
+	size = image.Pt(config.Width, config.Height)
+	params.imageSizes[name] = size
+	return size, nil
+}
+
+// overridePath returns the value of a param to be used as a path. An override that is relative is
+// joined to overridesDir first.
+func (params *definitionParams) overridePath(name string) string {
+	value := params.values[name]
+	if _, overridden := params.overrides[name]; !overridden || params.overridesDir == "" || value == "" || filepath.IsAbs(value) {
+		return value
+	}
+	return filepath.Join(params.overridesDir, value)
+}
+
+func tokenizeExpression(expression string) (tokens []string) {
+	for i := 0; i < len(expression); {
+		c := rune(expression[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || c == '.':
+			start := i
+			for i < len(expression) && (unicode.IsDigit(rune(expression[i])) || expression[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, expression[start:i])
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i < len(expression) && (unicode.IsLetter(rune(expression[i])) || unicode.IsDigit(rune(expression[i])) || expression[i] == '_' || expression[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, expression[start:i])
+		default:
+			tokens = append(tokens, string(c))
+			i++
+		}
+	}
+	return tokens
+}
+
+var kExpressionFunctions = map[string]func(arguments []float64) (float64, error){
+	"round": unaryExpressionFunction(math.Round),
+	"floor": unaryExpressionFunction(math.Floor),
+	"ceil":  unaryExpressionFunction(math.Ceil),
+	"min": func(arguments []float64) (float64, error) {
+		if len(arguments) != 2 {
+			return 0, fmt.Errorf("min takes 2 arguments")
+		}
+		return math.Min(arguments[0], arguments[1]), nil
+	},
+	"max": func(arguments []float64) (float64, error) {
+		if len(arguments) != 2 {
+			return 0, fmt.Errorf("max takes 2 arguments")
+		}
+		return math.Max(arguments[0], arguments[1]), nil
+	},
+}
+
+func unaryExpressionFunction(function func(float64) float64) func(arguments []float64) (float64, error) {
+	return func(arguments []float64) (float64, error) {
+		if len(arguments) != 1 {
+			return 0, fmt.Errorf("expected 1 argument")
+		}
+		return function(arguments[0]), nil
+	}
+}
+
+// expressionParser evaluates + - * / % over numbers, parentheses, unary minus and the functions
+// in kExpressionFunctions. A lone parameter can also evaluate to a string.
+type expressionParser struct {
+	params   *definitionParams
+	tokens   []string
+	position int
+}
+
+func (parser *expressionParser) peek() string {
+	if parser.position < len(parser.tokens) {
+		return parser.tokens[parser.position]
+	}
+	return ""
+}
+
+func (parser *expressionParser) next() string {
+	token := parser.peek()
+	parser.position++
+	return token
+}
+
+func (parser *expressionParser) parseSum() (interface{}, error) {
+	left, err := parser.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for parser.peek() == "+" || parser.peek() == "-" {
+		operator := parser.next()
+		right, err := parser.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = applyOperator(operator, left, right); err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+func (parser *expressionParser) parseProduct() (interface{}, error) {
+	left, err := parser.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for parser.peek() == "*" || parser.peek() == "/" || parser.peek() == "%" {
+		operator := parser.next()
+		right, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if left, err = applyOperator(operator, left, right); err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+func (parser *expressionParser) parseUnary() (interface{}, error) {
+	if parser.peek() == "-" {
+		parser.next()
+		value, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return applyOperator("-", 0.0, value)
+	}
+	return parser.parseOperand()
+}
+
+func (parser *expressionParser) parseOperand() (interface{}, error) {
+	token := parser.next()
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case token == "(":
+		value, err := parser.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if parser.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return value, nil
+	case unicode.IsDigit(rune(token[0])) || token[0] == '.':
+		number, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", token)
+		}
+		return number, nil
+	case unicode.IsLetter(rune(token[0])) || token[0] == '_':
+		if function, exists := kExpressionFunctions[token]; exists && parser.peek() == "(" {
+			return parser.parseCall(token, function)
+		}
+		return parser.params.lookup(token)
+	}
+	return nil, fmt.Errorf("unexpected %q", token)
+}
+
+func (parser *expressionParser) parseCall(name string, function func([]float64) (float64, error)) (interface{}, error) {
+	parser.next()
+	var arguments []float64
+	for parser.peek() != ")" {
+		if len(arguments) > 0 && parser.next() != "," {
+			return nil, fmt.Errorf("expected , in call to %s", name)
+		}
+		argument, err := parser.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		number, ok := argument.(float64)
+		if !ok {
+			return nil, fmt.Errorf("%s: argument %q is not a number", name, argument)
+		}
+		arguments = append(arguments, number)
+	}
+	parser.next()
+
+	result, err := function(arguments)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return result, nil
+}
+
+func applyOperator(operator string, left, right interface{}) (interface{}, error) {
+	a, leftIsNumber := left.(float64)
+	b, rightIsNumber := right.(float64)
+	if !leftIsNumber || !rightIsNumber {
+		return nil, fmt.Errorf("%q %s %q: operands must be numbers", formatParamValue(left), operator, formatParamValue(right))
+	}
+
+	switch operator {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return a / b, nil
+	case "%":
+		if b == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return math.Mod(a, b), nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", operator)
+}
@@ -0,0 +1,141 @@
+package glslfilter
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func newTestParams(t *testing.T) *definitionParams {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image.NewGray(image.Rect(0, 0, 40, 30))); err != nil {
+		t.Fatal(err)
+	}
+	return &definitionParams{
+		values: map[string]string{
+			"scale":  "2",
+			"half":   "0.5",
+			"name":   "crt",
+			"input":  "images/input.png",
+			"broken": "missing.png",
+		},
+		imageSizes: make(map[string]image.Point),
+		files:      definitionFiles{fsys: fstest.MapFS{"images/input.png": {Data: encoded.Bytes()}}, dir: "."},
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  interface{}
+	}{
+		{"plain text", "plain text"},
+		{"${scale}", 2},
+		{"${half}", 0.5},
+		{"${name}", "crt"},
+		{"${name}.png", "crt.png"},
+		{"out/${name}-${scale}x.png", "out/crt-2x.png"},
+		{"${1 + 2 * 3}", 7},
+		{"${(1 + 2) * 3}", 9},
+		{"${10 / 4}", 2.5},
+		{"${7 % 4}", 3},
+		{"${-scale - -1}", -1},
+		{"${2 * -(1 + half)}", -3},
+		{"${round(2.5)} ${floor(-0.5)} ${ceil(0.2)}", "3 -1 1"},
+		{"${min(scale, 3)}", 2},
+		{"${max(scale, half * 8)}", 4},
+		{"${input.width}", 40},
+		{"${input.height / 2 + input.width}", 55},
+		{"${3000000000}", 3000000000.0},
+		{"$${scale}", "${scale}"},
+		{"$$5 and ${scale}", "$5 and 2"},
+		{"${ scale }", 2},
+	}
+	for _, test := range tests {
+		got, err := newTestParams(t).interpolate(test.value)
+		if err != nil {
+			t.Errorf("%q: %v", test.value, err)
+		} else if got != test.want {
+			t.Errorf("%q: got %#v, want %#v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestInterpolateErrors(t *testing.T) {
+	tests := []struct {
+		value string
+		err   string
+	}{
+		{"${scale", `unterminated expression in "${scale"`},
+		{"${missing}", `${missing}: undefined parameter "missing"`},
+		{"${missing.width}", `${missing.width}: undefined parameter "missing"`},
+		{"${broken.width}", "${broken.width}: open missing.png: file does not exist"},
+		{"${}", "${}: unexpected end of expression"},
+		{"${1 +}", "${1 +}: unexpected end of expression"},
+		{"${1 2}", `${1 2}: unexpected "2"`},
+		{"${(1 + 2}", "${(1 + 2}: missing )"},
+		{"${1..2}", `${1..2}: invalid number "1..2"`},
+		{"${1 / 0}", "${1 / 0}: division by zero"},
+		{"${1 % 0}", "${1 % 0}: division by zero"},
+		{"${name * 2}", `${name * 2}: "crt" * "2": operands must be numbers`},
+		{"${round(1, 2)}", "${round(1, 2)}: round: expected 1 argument"},
+		{"${min(1)}", "${min(1)}: min: min takes 2 arguments"},
+		{"${max(1 2)}", "${max(1 2)}: expected , in call to max"},
+		{"${floor(name)}", `${floor(name)}: floor: argument "crt" is not a number`},
+		{"${#}", `${#}: unexpected "#"`},
+	}
+	for _, test := range tests {
+		_, err := newTestParams(t).interpolate(test.value)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%q: got error %v, want %s", test.value, err, test.err)
+		}
+	}
+}
+
+func TestImageSizeIsCached(t *testing.T) {
+	params := newTestParams(t)
+	if _, err := params.interpolate("${input.width}"); err != nil {
+		t.Fatal(err)
+	}
+	params.files = definitionFiles{fsys: fstest.MapFS{}, dir: "."}
+	if got, err := params.interpolate("${input.height}"); err != nil || got != 30 {
+		t.Errorf("got %v, %v, want 30", got, err)
+	}
+}
+
+func TestOverridesResolveAgainstParamsDir(t *testing.T) {
+	definitionDir, paramsDir := t.TempDir(), t.TempDir()
+	definition := `
+params:
+  source: input.png
+  mode: output
+stages:
+  - fragmentShaderPath: ${mode}.frag
+    defines:
+      MODE: ${mode}
+    textures:
+      - name: inputTexture
+        path: ${source}
+`
+	loaded, err := LoadDefinitionWithOptions([]byte(definition), LoadOptions{
+		Params:    map[string]string{"source": "photo.png", "mode": "output"},
+		ParamsDir: paramsDir,
+		Dir:       definitionDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stage := loaded.Stages[0]
+	if want := filepath.Join(paramsDir, "photo.png"); stage.Textures[0].Path != want {
+		t.Errorf("texture path is %q, want %q", stage.Textures[0].Path, want)
+	}
+	if want := filepath.Join(paramsDir, "output.frag"); stage.FragmentShaderPath != want {
+		t.Errorf("fragment shader path is %q, want %q", stage.FragmentShaderPath, want)
+	}
+	if stage.Defines["MODE"] != "output" {
+		t.Errorf("define is %q, want the override as it was given", stage.Defines["MODE"])
+	}
+}
@@ -0,0 +1,218 @@
+package glslfilter
+
+import (
+	"errors"
+	"image"
+	"io/fs"
+	"runtime"
+)
+
+// Option configures BuildPipeline.
+type Option func(options *pipelineOptions)
+
+type pipelineOptions struct {
+	contextFunc func(renderSize image.Point) (ContextProvider, error)
+	debug       bool
+	fsys        fs.FS
+	textures    map[string]image.Image
+}
+
+// WithContext renders with context. Without it, the caller's context current on the calling thread
+// is used.
+func WithContext(context ContextProvider) Option {
+	return func(options *pipelineOptions) {
+		options.contextFunc = func(image.Point) (ContextProvider, error) {
+			return context, nil
+		}
+	}
+}
+
+// WithContextFunc creates the context once the render size is known, for providers whose surface
+// has to match it such as windows.
+func WithContextFunc(contextFunc func(renderSize image.Point) (ContextProvider, error)) Option {
+	return func(options *pipelineOptions) {
+		options.contextFunc = contextFunc
+	}
+}
+
+// WithDebug enables GL debug output.
+func WithDebug(debug bool) Option {
+	return func(options *pipelineOptions) {
+		options.debug = debug
+	}
+}
+
+// WithFS loads the definition's shaders and textures from fsys, as for a definition loaded with
+// LoadDefinitionFromFS.
+func WithFS(fsys fs.FS) Option {
+	return func(options *pipelineOptions) {
+		options.fsys = fsys
+	}
+}
+
+// WithTextures replaces the data of the definition's textures by name instead of loading them.
+func WithTextures(textures map[string]image.Image) Option {
+	return func(options *pipelineOptions) {
+		options.textures = textures
+	}
+}
+
+// BuildPipeline loads the shaders and textures of a definition, creates an engine sized to its
+// render size and initializes it with the definition's stages. Closing the engine releases
+// everything it created.
+//
+// Like NewEngineWithContext, it locks the calling goroutine to its OS thread, even with the default
+// CallerManagedContext, and the engine must only be used from that goroutine. The thread is
+// unlocked again if it fails.
+func BuildPipeline(definition Definition, opts ...Option) (engine *Engine, err error) {
+	options := pipelineOptions{
+		contextFunc: func(image.Point) (ContextProvider, error) {
+			return &CallerManagedContext{}, nil
+		},
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	files := definitionFiles{fsys: options.fsys}
+
+	stageTextures, texturesByName, err := definition.loadTextures(files, options.textures)
+	if err != nil {
+		return nil, err
+	}
+	renderSize, err := definition.ResolveRenderSize(texturesByName)
+	if err != nil {
+		return nil, err
+	}
+
+	context, err := options.contextFunc(renderSize)
+	if err != nil {
+		return nil, err
+	}
+	engine, err = NewEngineWithContext(context, image.Rectangle{Max: renderSize}, options.debug)
+	if err != nil {
+		return nil, err
+	}
+	engine.SetDefaultFormat(definition.Render.Format)
+
+	stages, err := definition.createStages(files, stageTextures)
+	if err != nil {
+		engine.Close()
+		runtime.UnlockOSThread()
+		return nil, err
+	}
+	if err = engine.Init(stages); err != nil {
+		deleteStages(stages)
+		engine.Close()
+		runtime.UnlockOSThread()
+		return nil, err
+	}
+	engine.sizeRule = definition.Render.Size
+	return engine, nil
+}
+
+// LoadPipeline loads the definition at definitionPath in fsys, e.g. an embed.FS or a zip.Reader,
+// along with the shaders and textures it refers to, and creates its stages for Engine.Init. The
+// render width and height of the returned definition are set to the resolved render size. A GL
+// context must be current.
+func LoadPipeline(fsys fs.FS, definitionPath string) (definition Definition, stages []*FilterStage, err error) {
+	definition, err = LoadDefinitionFromFS(fsys, definitionPath, LoadOptions{})
+	if err != nil {
+		return definition, nil, err
+	}
+	files := definitionFiles{fsys: fsys}
+
+	stageTextures, texturesByName, err := definition.loadTextures(files, nil)
+	if err != nil {
+		return definition, nil, err
+	}
+	renderSize, err := definition.ResolveRenderSize(texturesByName)
+	if err != nil {
+		return definition, nil, err
+	}
+	definition.Render.Width, definition.Render.Height = renderSize.X, renderSize.Y
+
+	stages, err = definition.createStages(files, stageTextures)
+	return definition, stages, err
+}
+
+// loadTextures loads the textures of every stage, unless their data is set in the definition or in
+// overrides. texturesByName holds the first texture with each name.
+func (definition Definition) loadTextures(files definitionFiles, overrides map[string]image.Image) (stageTextures [][]Texture, texturesByName map[string]image.Image, err error) {
+	stageTextures = make([][]Texture, len(definition.Stages))
+	texturesByName = make(map[string]image.Image)
+	for i, stageDefinition := range definition.Stages {
+		for _, textureDefinition := range stageDefinition.Textures {
+			textureData, exists := overrides[textureDefinition.Name]
+			if !exists {
+				textureData = textureDefinition.Data
+			}
+			if textureData == nil {
+				if textureData, err = files.loadTextureData(textureDefinition.Path); err != nil {
+					return nil, nil, err
+				}
+			}
+
+			stageTextures[i] = append(stageTextures[i], Texture{
+				Data:        textureData,
+				BindingName: textureDefinition.Name,
+				Filter:      int32(textureDefinition.Filter),
+			})
+			if _, exists := texturesByName[textureDefinition.Name]; !exists {
+				texturesByName[textureDefinition.Name] = textureData
+			}
+		}
+	}
+	return stageTextures, texturesByName, nil
+}
+
+// createStages creates the definition's stages with textures from loadTextures. A GL context must
+// be current.
+func (definition Definition) createStages(files definitionFiles, stageTextures [][]Texture) (stages []*FilterStage, err error) {
+	for i, stageDefinition := range definition.Stages {
+		stage, err := createStage(files, definition.IncludePaths, stageDefinition, stageTextures[i])
+		if err != nil {
+			deleteStages(stages)
+			var uniformErr *UniformError
+			if errors.As(err, &uniformErr) {
+				uniformErr.Stage = i
+			}
+			return nil, err
+		}
+		stages = append(stages, stage)
+	}
+	return stages, nil
+}
+
+func createStage(files definitionFiles, includePaths []string, stageDefinition StageDefinition, textures []Texture) (stage *FilterStage, err error) {
+	fragmentShaderSource := ShaderSource{Text: stageDefinition.FragmentShaderSource}
+	if fragmentShaderSource.Text == "" {
+		fragmentShaderSource.File = stageDefinition.FragmentShaderPath
+		if fragmentShaderSource.Text, err = files.loadFragmentShader(stageDefinition.FragmentShaderPath); err != nil {
+			return nil, err
+		}
+	}
+	if stageDefinition.Managed {
+		if fragmentShaderSource, err = withManagedPrelude(fragmentShaderSource, stageDefinition); err != nil {
+			return nil, err
+		}
+	}
+	if fragmentShaderSource, err = preprocessShader(fragmentShaderSource, files, includePaths, stageDefinition.Defines); err != nil {
+		return nil, err
+	}
+
+	stage, err = NewFilterStageWithSource(fragmentShaderSource, textures, stageDefinition.Uniforms)
+	if err != nil {
+		return nil, err
+	}
+	stage.Output = stageDefinition.Output
+	stage.Inputs = stageDefinition.Inputs
+	stage.Size = stageDefinition.Size
+	stage.Format = stageDefinition.Format
+	return stage, nil
+}
+
+func deleteStages(stages []*FilterStage) {
+	for _, stage := range stages {
+		stage.Delete()
+	}
+}
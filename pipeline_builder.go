@@ -0,0 +1,212 @@
+package glslfilter
+
+import (
+	"fmt"
+	"image"
+)
+
+// Pipeline builds a Definition in code, for BuildPipeline:
+//
+//	engine, err := glslfilter.NewPipeline().
+//		Stage(source).
+//		Texture("inputTexture", photo).
+//		Uniform("strength", "float", 0.5).
+//		SizeFromTexture("inputTexture", 1).
+//		Build()
+//
+// Texture, Uniform and the other stage settings apply to the stage added last. The first error is
+// returned by Definition and Build.
+type Pipeline struct {
+	definition Definition
+	err        error
+}
+
+func NewPipeline() *Pipeline {
+	return new(Pipeline)
+}
+
+// Size sets the render size.
+func (pipeline *Pipeline) Size(width, height int) *Pipeline {
+	pipeline.definition.Render.Width = width
+	pipeline.definition.Render.Height = height
+	return pipeline
+}
+
+// SizeFromTexture derives the render size from a texture, scaled by scale.
+func (pipeline *Pipeline) SizeFromTexture(bindingName string, scale float64) *Pipeline {
+	pipeline.definition.Render.Size.FromTexture = bindingName
+	pipeline.definition.Render.Size.Scale = scale
+	return pipeline
+}
+
+// RenderSize sets all the rules deriving the render size from a texture.
+func (pipeline *Pipeline) RenderSize(size RenderSize) *Pipeline {
+	pipeline.definition.Render.Size = size
+	return pipeline
+}
+
+// DefaultFormat sets the format of stages that don't set their own.
+func (pipeline *Pipeline) DefaultFormat(format TargetFormat) *Pipeline {
+	pipeline.definition.Render.Format = format
+	return pipeline
+}
+
+// IncludePath adds a directory to search for the files named by #include directives.
+func (pipeline *Pipeline) IncludePath(dir string) *Pipeline {
+	pipeline.definition.IncludePaths = append(pipeline.definition.IncludePaths, dir)
+	return pipeline
+}
+
+// Stage adds a stage running fragmentShaderSource.
+func (pipeline *Pipeline) Stage(fragmentShaderSource string) *Pipeline {
+	pipeline.definition.Stages = append(pipeline.definition.Stages, StageDefinition{FragmentShaderSource: fragmentShaderSource})
+	return pipeline
+}
+
+// StageFile adds a stage running the fragment shader at fragmentShaderPath.
+func (pipeline *Pipeline) StageFile(fragmentShaderPath string) *Pipeline {
+	pipeline.definition.Stages = append(pipeline.definition.Stages, StageDefinition{FragmentShaderPath: fragmentShaderPath})
+	return pipeline
+}
+
+// Texture binds data to the sampler uniform bindingName.
+func (pipeline *Pipeline) Texture(bindingName string, data image.Image) *Pipeline {
+	if stage := pipeline.lastStage("Texture"); stage != nil {
+		stage.Textures = append(stage.Textures, TextureDefinition{Name: bindingName, Data: data})
+	}
+	return pipeline
+}
+
+// TextureFile binds the image at path to the sampler uniform bindingName.
+func (pipeline *Pipeline) TextureFile(bindingName string, path string) *Pipeline {
+	if stage := pipeline.lastStage("TextureFile"); stage != nil {
+		stage.Textures = append(stage.Textures, TextureDefinition{Name: bindingName, Path: path})
+	}
+	return pipeline
+}
+
+// Filter sets the filter of the texture added last.
+func (pipeline *Pipeline) Filter(filter TextureFilterType) *Pipeline {
+	stage := pipeline.lastStage("Filter")
+	if stage == nil {
+		return pipeline
+	}
+	if len(stage.Textures) == 0 {
+		pipeline.setError(fmt.Errorf("Filter called before Texture"))
+		return pipeline
+	}
+	stage.Textures[len(stage.Textures)-1].Filter = filter
+	return pipeline
+}
+
+// Uniform sets a uniform. typeName is written as in a definition, e.g. "float" or "[]IntVec2",
+// and value takes the same forms as in a definition or Go numbers and slices of them, e.g. []int32
+// for an IntVec2. The elements of an array of vectors or matrices can also be in one flat slice.
+func (pipeline *Pipeline) Uniform(name string, typeName string, value interface{}) *Pipeline {
+	stage := pipeline.lastStage("Uniform")
+	if stage == nil {
+		return pipeline
+	}
+	uniformType, err := ParseUniformType(typeName)
+	if err != nil {
+		pipeline.setError(fmt.Errorf("uniform %s: %v", name, err))
+		return pipeline
+	}
+	stage.Uniforms = append(stage.Uniforms, UniformDefinition{Name: name, Type: uniformType, Value: value})
+	return pipeline
+}
+
+// RowMajor makes the matrix value of the uniform added last read row by row.
+func (pipeline *Pipeline) RowMajor() *Pipeline {
+	stage := pipeline.lastStage("RowMajor")
+	if stage == nil {
+		return pipeline
+	}
+	if len(stage.Uniforms) == 0 {
+		pipeline.setError(fmt.Errorf("RowMajor called before Uniform"))
+		return pipeline
+	}
+	stage.Uniforms[len(stage.Uniforms)-1].RowMajor = true
+	return pipeline
+}
+
+// Define adds a #define to the stage's shader.
+func (pipeline *Pipeline) Define(name string, value string) *Pipeline {
+	stage := pipeline.lastStage("Define")
+	if stage == nil {
+		return pipeline
+	}
+	if stage.Defines == nil {
+		stage.Defines = make(map[string]string)
+	}
+	stage.Defines[name] = value
+	return pipeline
+}
+
+// Managed generates the stage's declarations of built-ins, textures, inputs and uniforms, so its
+// shader only has to declare its own functions.
+func (pipeline *Pipeline) Managed() *Pipeline {
+	if stage := pipeline.lastStage("Managed"); stage != nil {
+		stage.Managed = true
+	}
+	return pipeline
+}
+
+// Output names the stage's result so later stages can read it with Input.
+func (pipeline *Pipeline) Output(name string) *Pipeline {
+	if stage := pipeline.lastStage("Output"); stage != nil {
+		stage.Output = name
+	}
+	return pipeline
+}
+
+// Input binds the output of an earlier stage to the sampler uniform bindingName.
+func (pipeline *Pipeline) Input(target string, bindingName string) *Pipeline {
+	if stage := pipeline.lastStage("Input"); stage != nil {
+		stage.Inputs = append(stage.Inputs, StageInput{Target: target, BindingName: bindingName})
+	}
+	return pipeline
+}
+
+// StageSize sets the size of the stage's output.
+func (pipeline *Pipeline) StageSize(size TargetSize) *Pipeline {
+	if stage := pipeline.lastStage("StageSize"); stage != nil {
+		stage.Size = size
+	}
+	return pipeline
+}
+
+// StageFormat sets the format of the stage's output.
+func (pipeline *Pipeline) StageFormat(format TargetFormat) *Pipeline {
+	if stage := pipeline.lastStage("StageFormat"); stage != nil {
+		stage.Format = format
+	}
+	return pipeline
+}
+
+// Definition returns the definition built so far.
+func (pipeline *Pipeline) Definition() (Definition, error) {
+	return pipeline.definition, pipeline.err
+}
+
+// Build calls BuildPipeline with the definition.
+func (pipeline *Pipeline) Build(opts ...Option) (*Engine, error) {
+	if pipeline.err != nil {
+		return nil, pipeline.err
+	}
+	return BuildPipeline(pipeline.definition, opts...)
+}
+
+func (pipeline *Pipeline) lastStage(method string) *StageDefinition {
+	if len(pipeline.definition.Stages) == 0 {
+		pipeline.setError(fmt.Errorf("%s called before Stage", method))
+		return nil
+	}
+	return &pipeline.definition.Stages[len(pipeline.definition.Stages)-1]
+}
+
+func (pipeline *Pipeline) setError(err error) {
+	if pipeline.err == nil {
+		pipeline.err = err
+	}
+}
@@ -0,0 +1,294 @@
+package glslfilter
+
+import (
+	"fmt"
+	"image"
+	"log"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+type renderTargetSpec struct {
+	size   image.Point
+	format TargetFormat
+}
+
+type renderPassInput struct {
+	bindingName string
+	target      int
+	filter      int32
+}
+
+type renderPass struct {
+	stage  *FilterStage
+	index  int
+	inputs []renderPassInput
+	target int
+}
+
+type renderGraph struct {
+	passes      []renderPass
+	targets     []renderTargetSpec
+	finalTarget int
+}
+
+type renderGraphNode struct {
+	stage      *FilterStage
+	index      int
+	output     string
+	inputs     []StageInput
+	dependents []int
+	size       image.Point
+	format     TargetFormat
+}
+
+// buildRenderGraph orders the stages by their target dependencies and assigns each pass a render
+// target slot. Slots are recycled as soon as every consumer of the target they hold has run, so
+// a linear chain of same-sized stages needs only two targets no matter how many stages it has.
+// If the final stage doesn't render at the render size, a pass of resampleStage is appended to
+// scale it. Stages without a format render to defaultFormat.
+func buildRenderGraph(stages []*FilterStage, renderSize image.Point, defaultFormat TargetFormat, resampleStage *FilterStage) (graph renderGraph, err error) {
+	if len(stages) == 0 {
+		return graph, fmt.Errorf("no stages defined")
+	}
+
+	nodes := make([]renderGraphNode, len(stages))
+	producers := make(map[string]int)
+	for i, stage := range stages {
+		output := stage.Output
+		if output == "" {
+			output = fmt.Sprintf("stage%d", i)
+		}
+		if _, exists := producers[output]; exists {
+			return graph, fmt.Errorf("stage %d: output %q is already defined", i, output)
+		}
+		producers[output] = i
+		nodes[i] = renderGraphNode{stage: stage, index: i, output: output, format: stage.Format}
+		if nodes[i].format == 0 {
+			nodes[i].format = defaultFormat
+		}
+	}
+
+	for i := range nodes {
+		node := &nodes[i]
+		if i > 0 && node.stage.hasUniform(kPreviousResultBindingName) {
+			node.inputs = append(node.inputs, StageInput{
+				Target:      nodes[i-1].output,
+				BindingName: kPreviousResultBindingName,
+			})
+		}
+		for _, input := range node.stage.Inputs {
+			producer, exists := producers[input.Target]
+			if !exists {
+				return graph, fmt.Errorf("stage %d: input %q references unknown output %q", i, input.BindingName, input.Target)
+			}
+			if producer == i {
+				return graph, fmt.Errorf("stage %d: input %q references its own output", i, input.BindingName)
+			}
+			node.inputs = append(node.inputs, input)
+		}
+		for _, input := range node.inputs {
+			producer := producers[input.Target]
+			nodes[producer].dependents = append(nodes[producer].dependents, i)
+		}
+	}
+
+	if err = resolveTargetSizes(nodes, producers, renderSize); err != nil {
+		return graph, err
+	}
+
+	order, err := sortRenderGraph(nodes)
+	if err != nil {
+		return graph, err
+	}
+
+	// only stages the final result depends on need to run
+	finalNode := len(nodes) - 1
+	required := make([]bool, len(nodes))
+	required[finalNode] = true
+	for j := len(order) - 1; j >= 0; j-- {
+		i := order[j]
+		if !required[i] {
+			continue
+		}
+		for _, input := range nodes[i].inputs {
+			required[producers[input.Target]] = true
+		}
+	}
+
+	position := make(map[int]int)
+	for _, i := range order {
+		if !required[i] {
+			log.Printf("stage %d (%s) does not contribute to the final result, skipping", i, nodes[i].output)
+			continue
+		}
+		position[i] = len(position)
+	}
+
+	lastUse := make([]int, len(nodes))
+	for i := range nodes {
+		lastUse[i] = -1
+		for _, dependent := range nodes[i].dependents {
+			if p, ok := position[dependent]; ok && p > lastUse[i] {
+				lastUse[i] = p
+			}
+		}
+	}
+	lastUse[finalNode] = len(position)
+
+	slots := make([]int, len(nodes))
+	freeSlots := make(map[renderTargetSpec][]int)
+	for _, i := range order {
+		if !required[i] {
+			continue
+		}
+		node := nodes[i]
+
+		spec := renderTargetSpec{node.size, node.format}
+		if free := freeSlots[spec]; len(free) > 0 {
+			slots[i] = free[len(free)-1]
+			freeSlots[spec] = free[:len(free)-1]
+		} else {
+			slots[i] = len(graph.targets)
+			graph.targets = append(graph.targets, spec)
+		}
+
+		pass := renderPass{stage: node.stage, index: i, target: slots[i]}
+		for _, input := range node.inputs {
+			producer := producers[input.Target]
+			pass.inputs = append(pass.inputs, renderPassInput{
+				bindingName: input.BindingName,
+				target:      slots[producer],
+				filter:      inputFilter(input.Filter, nodes[producer].size, node.size),
+			})
+		}
+		graph.passes = append(graph.passes, pass)
+
+		released := make(map[int]bool)
+		for _, input := range node.inputs {
+			producer := producers[input.Target]
+			if lastUse[producer] == position[i] && !released[producer] {
+				released[producer] = true
+				producerSpec := graph.targets[slots[producer]]
+				freeSlots[producerSpec] = append(freeSlots[producerSpec], slots[producer])
+			}
+		}
+	}
+	graph.finalTarget = slots[finalNode]
+
+	if nodes[finalNode].size != renderSize {
+		spec := renderTargetSpec{renderSize, nodes[finalNode].format}
+		resampleTarget := len(graph.targets)
+		if free := freeSlots[spec]; len(free) > 0 {
+			resampleTarget = free[len(free)-1]
+		} else {
+			graph.targets = append(graph.targets, spec)
+		}
+		graph.passes = append(graph.passes, renderPass{
+			stage:  resampleStage,
+			index:  len(stages),
+			inputs: []renderPassInput{{kPreviousResultBindingName, graph.finalTarget, gl.LINEAR}},
+			target: resampleTarget,
+		})
+		graph.finalTarget = resampleTarget
+	}
+
+	return graph, nil
+}
+
+// resolveTargetSizes computes the output size of every node, following relativeTo references to
+// other targets before falling back on the render size.
+func resolveTargetSizes(nodes []renderGraphNode, producers map[string]int, renderSize image.Point) error {
+	const (
+		unresolved = iota
+		resolving
+		resolved
+	)
+	state := make([]int, len(nodes))
+
+	var resolve func(i int) error
+	resolve = func(i int) error {
+		switch state[i] {
+		case resolved:
+			return nil
+		case resolving:
+			return fmt.Errorf("stage %d: output size of %q depends on itself", i, nodes[i].output)
+		}
+		state[i] = resolving
+
+		size := nodes[i].stage.Size
+		if _, err := size.check(); err != nil {
+			return fmt.Errorf("stage %d: %v", i, err)
+		}
+		base := renderSize
+		if size.RelativeTo != "" {
+			relative, exists := producers[size.RelativeTo]
+			if !exists {
+				return fmt.Errorf("stage %d: size is relative to unknown output %q", i, size.RelativeTo)
+			}
+			if err := resolve(relative); err != nil {
+				return err
+			}
+			base = nodes[relative].size
+		}
+
+		nodes[i].size = size.resolve(base)
+		state[i] = resolved
+		return nil
+	}
+
+	for i := range nodes {
+		if err := resolve(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inputFilter keeps exact texel fetches between same-sized targets and smooths resampled ones
+// unless the definition asks for a specific filter.
+func inputFilter(filter TextureFilterType, sourceSize, targetSize image.Point) int32 {
+	if filter != 0 {
+		return int32(filter)
+	}
+	if sourceSize == targetSize {
+		return gl.NEAREST
+	}
+	return gl.LINEAR
+}
+
+// sortRenderGraph returns a topological order of the nodes, keeping definition order wherever the
+// dependencies allow it.
+func sortRenderGraph(nodes []renderGraphNode) (order []int, err error) {
+	pending := make([]int, len(nodes))
+	for i := range nodes {
+		for _, dependent := range nodes[i].dependents {
+			pending[dependent]++
+		}
+	}
+
+	done := make([]bool, len(nodes))
+	for len(order) < len(nodes) {
+		next := -1
+		for i := range nodes {
+			if !done[i] && pending[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			for i := range nodes {
+				if !done[i] {
+					return nil, fmt.Errorf("stage %d (%s) is part of a dependency cycle", i, nodes[i].output)
+				}
+			}
+		}
+
+		done[next] = true
+		order = append(order, next)
+		for _, dependent := range nodes[next].dependents {
+			pending[dependent]--
+		}
+	}
+	return order, nil
+}
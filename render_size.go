@@ -0,0 +1,128 @@
+package glslfilter
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RenderSize derives the render size from one of the definition's textures instead of spelling
+// it out. The texture size is multiplied by Scale, then cropped or extended to Aspect according to
+// Fit, then scaled down uniformly to stay within MaxWidth and MaxHeight.
+type RenderSize struct {
+	FromTexture string `yaml:"fromTexture"`
+	Scale       float64
+	MaxWidth    int `yaml:"maxWidth"`
+	MaxHeight   int `yaml:"maxHeight"`
+	Aspect      AspectRatio
+	Fit         FitMode
+}
+
+// AspectRatio is a width / height ratio, written either as a number or as "width:height".
+type AspectRatio float64
+
+// FitMode decides how a size is adjusted to an aspect ratio: FitContain shrinks one dimension,
+// FitCover grows one.
+type FitMode string
+
+const (
+	FitContain FitMode = "contain"
+	FitCover   FitMode = "cover"
+)
+
+func (aspect *AspectRatio) UnmarshalYAML(value *yaml.Node) (err error) {
+	var rawString string
+	if err = value.Decode(&rawString); err != nil {
+		return err
+	}
+
+	if separator := strings.IndexByte(rawString, ':'); separator != -1 {
+		width, widthErr := strconv.ParseFloat(strings.TrimSpace(rawString[:separator]), 64)
+		height, heightErr := strconv.ParseFloat(strings.TrimSpace(rawString[separator+1:]), 64)
+		if widthErr != nil || heightErr != nil || width <= 0 || height <= 0 {
+			return newDefinitionError(value, "invalid aspect ratio %q", rawString)
+		}
+		*aspect = AspectRatio(width / height)
+		return nil
+	}
+
+	ratio, err := strconv.ParseFloat(rawString, 64)
+	if err != nil || ratio <= 0 {
+		return newDefinitionError(value, "invalid aspect ratio %q", rawString)
+	}
+	*aspect = AspectRatio(ratio)
+	return nil
+}
+
+func (fit *FitMode) UnmarshalYAML(value *yaml.Node) (err error) {
+	var rawString string
+	if err = value.Decode(&rawString); err != nil {
+		return err
+	}
+
+	switch FitMode(strings.ToLower(rawString)) {
+	case FitContain:
+		*fit = FitContain
+	case FitCover:
+		*fit = FitCover
+	default:
+		return newDefinitionError(value, "unknown fit mode %q", rawString)
+	}
+	return nil
+}
+
+// Resolve applies the size rules to the size of the source texture.
+func (size RenderSize) Resolve(textureSize image.Point) image.Point {
+	scale := size.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	width := float64(textureSize.X) * scale
+	height := float64(textureSize.Y) * scale
+
+	if size.Aspect > 0 {
+		aspect := float64(size.Aspect)
+		shrinkWidth := width/height > aspect
+		if size.Fit == FitCover {
+			shrinkWidth = !shrinkWidth
+		}
+		if shrinkWidth {
+			width = height * aspect
+		} else {
+			height = width / aspect
+		}
+	}
+
+	if size.MaxWidth > 0 && width > float64(size.MaxWidth) {
+		height *= float64(size.MaxWidth) / width
+		width = float64(size.MaxWidth)
+	}
+	if size.MaxHeight > 0 && height > float64(size.MaxHeight) {
+		width *= float64(size.MaxHeight) / height
+		height = float64(size.MaxHeight)
+	}
+
+	return image.Pt(int(math.Max(1, math.Round(width))), int(math.Max(1, math.Round(height))))
+}
+
+// ResolveRenderSize returns the render size of the definition. textures maps texture names to
+// their loaded data and is only needed when the size comes from a texture.
+func (definition Definition) ResolveRenderSize(textures map[string]image.Image) (image.Point, error) {
+	source := definition.Render.Size.FromTexture
+	if source == "" {
+		if definition.Render.Width <= 0 || definition.Render.Height <= 0 {
+			return image.Point{}, fmt.Errorf("render width and height or size.fromTexture must be set")
+		}
+		return image.Pt(definition.Render.Width, definition.Render.Height), nil
+	}
+
+	texture, exists := textures[source]
+	if !exists {
+		return image.Point{}, fmt.Errorf("render size: no texture named %s", source)
+	}
+	return definition.Render.Size.Resolve(texture.Bounds().Size()), nil
+}
@@ -0,0 +1,142 @@
+package glslfilter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// kPreludeFile is the origin reported for lines of a managed stage's prelude.
+const kPreludeFile = "<prelude>"
+
+const kManagedPreludeExtensions = `#version 330 core
+#extension GL_ARB_separate_shader_objects : enable
+#extension GL_ARB_explicit_uniform_location : enable
+#extension GL_ARB_shading_language_420pack : enable
+`
+
+const kManagedPreludeDoubleExtension = `#extension GL_ARB_gpu_shader_fp64 : enable
+`
+
+const kManagedPreludeBuiltins = `
+layout(location = 0) in vec2 fragTexCoord;
+layout(location = 1) uniform ivec2 outputResolution;
+layout(binding = 0) uniform sampler2D previousResult;
+layout(location = 0) out vec4 fragColor;
+`
+
+// managedPrelude declares everything a managed stage's shader can use: the built-in inputs and
+// outputs, then the stage's textures and inputs as samplers bound to the units after
+// previousResult's, its uniforms with the types and array lengths of their definitions, and last
+// the engine's other uniforms, unless a definition uniform has the same name.
+func managedPrelude(stageDefinition StageDefinition) (prelude string, err error) {
+	var builder strings.Builder
+	builder.WriteString(kManagedPreludeExtensions)
+	for _, uniformDefinition := range stageDefinition.Uniforms {
+		if uniformDefinition.Type.ScalarType == Double {
+			builder.WriteString(kManagedPreludeDoubleExtension)
+			break
+		}
+	}
+	builder.WriteString(kManagedPreludeBuiltins)
+
+	declared := map[string]bool{
+		kViewportSizeBindingName:   true,
+		kPreviousResultBindingName: true,
+	}
+	binding := 1
+	var samplerNames []string
+	declareSampler := func(bindingName string) {
+		if declared[bindingName] {
+			return
+		}
+		declared[bindingName] = true
+		samplerNames = append(samplerNames, bindingName)
+		fmt.Fprintf(&builder, "layout(binding = %d) uniform sampler2D %s;\n", binding, bindingName)
+		binding++
+	}
+	for _, texture := range stageDefinition.Textures {
+		declareSampler(texture.Name)
+	}
+	for _, input := range stageDefinition.Inputs {
+		declareSampler(input.BindingName)
+	}
+
+	for _, uniformDefinition := range stageDefinition.Uniforms {
+		if declared[uniformDefinition.Name] {
+			continue
+		}
+		declared[uniformDefinition.Name] = true
+
+		value, err := normalizeUniformValue(uniformDefinition)
+		if err != nil {
+			return "", err
+		}
+		typeName := glslTypeName(uniformDefinition.Type)
+		if uniformDefinition.Type.IsArray {
+			fmt.Fprintf(&builder, "uniform %s %s[%d];\n", typeName, uniformDefinition.Name, uniformArrayLength(uniformDefinition.Type, value))
+		} else {
+			fmt.Fprintf(&builder, "uniform %s %s;\n", typeName, uniformDefinition.Name)
+		}
+	}
+
+	declareBuiltin := func(typeName string, name string) {
+		if !declared[name] {
+			declared[name] = true
+			fmt.Fprintf(&builder, "uniform %s %s;\n", typeName, name)
+		}
+	}
+	declareBuiltin("float", kTimeUniformName)
+	declareBuiltin("float", kTimeDeltaUniformName)
+	declareBuiltin("int", kFrameUniformName)
+	declareBuiltin("int", kStageIndexUniformName)
+	declareBuiltin("vec2", kMouseUniformName)
+	declareBuiltin("ivec2", kPreviousResultBindingName+kResolutionUniformSuffix)
+	for _, samplerName := range samplerNames {
+		declareBuiltin("ivec2", samplerName+kResolutionUniformSuffix)
+	}
+	return builder.String(), nil
+}
+
+// withManagedPrelude returns source with the prelude of a managed stage before it.
+func withManagedPrelude(source ShaderSource, stageDefinition StageDefinition) (result ShaderSource, err error) {
+	prelude, err := managedPrelude(stageDefinition)
+	if err != nil {
+		return source, err
+	}
+
+	preludeLines := strings.Count(prelude, "\n")
+	sourceLines := strings.Count(source.Text, "\n") + 1
+	result = ShaderSource{Text: prelude + source.Text, File: source.File}
+	result.Origins = make([]SourceLine, 0, preludeLines+sourceLines)
+	for i := 1; i <= preludeLines; i++ {
+		result.Origins = append(result.Origins, SourceLine{File: kPreludeFile, Line: i})
+	}
+	for i := 1; i <= sourceLines; i++ {
+		result.Origins = append(result.Origins, source.Origin(i))
+	}
+	return result, nil
+}
+
+// glslTypeName returns the GLSL name of a uniform type that's been checked by normalizeUniformValue.
+func glslTypeName(uniformType UniformType) string {
+	scalar, vector, matrix := "float", "vec", "mat"
+	switch uniformType.ScalarType {
+	case Int:
+		scalar, vector = "int", "ivec"
+	case Uint:
+		scalar, vector = "uint", "uvec"
+	case Bool:
+		scalar, vector = "bool", "bvec"
+	case Double:
+		scalar, vector, matrix = "double", "dvec", "dmat"
+	}
+	switch {
+	case uniformType.Columns > 0 && uniformType.Columns == uniformType.Rows:
+		return fmt.Sprintf("%s%d", matrix, uniformType.Columns)
+	case uniformType.Columns > 0:
+		return fmt.Sprintf("%s%dx%d", matrix, uniformType.Columns, uniformType.Rows)
+	case uniformType.VectorSize > 0:
+		return fmt.Sprintf("%s%d", vector, uniformType.VectorSize)
+	}
+	return scalar
+}
@@ -0,0 +1,232 @@
+package glslfilter
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// kIncludePattern matches #include "file" and #include <file>.
+var kIncludePattern = regexp.MustCompile(`^\s*#\s*include\s+(?:"([^"]+)"|<([^>]+)>)\s*(?://.*)?$`)
+
+var kVersionPattern = regexp.MustCompile(`^\s*#\s*version\b`)
+
+var kDefineNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// kConditionalPattern matches the directives that open, continue and close #if blocks.
+var kConditionalPattern = regexp.MustCompile(`^\s*#\s*(if|ifdef|ifndef|elif|else|endif)\b\s*(.*?)\s*(?://.*)?$`)
+
+// kDefinesFile is the origin reported for lines generated from a stage's defines.
+const kDefinesFile = "<defines>"
+
+type shaderPreprocessor struct {
+	files        definitionFiles
+	includePaths []string
+	lines        []string
+	origins      []SourceLine
+	// including is the chain of files being included, to catch cycles
+	including []string
+	// included holds every file expanded so far, which aren't expanded again
+	included map[string]bool
+	// conditionals are the #if blocks around the current line
+	conditionals []conditionalBlock
+}
+
+// conditionalBlock is an #if block as far as it can be followed without evaluating macros: only
+// #if 0 and #if 1 are known to skip or take a branch.
+type conditionalBlock struct {
+	// skipped is whether the current branch is known not to be compiled
+	skipped bool
+	// taken is whether an earlier or the current branch is known to be compiled
+	taken bool
+}
+
+// preprocessShader expands the #include directives of source and adds a #define for each of
+// defines after its #version directive. A quoted include is looked up next to the file including
+// it and then in includePaths, and an include in angle brackets only in includePaths; inline sources
+// have no directory of their own. Each file is expanded once, where it's first included. Includes
+// in block comments and #if 0 blocks are left alone, but other conditions aren't evaluated. The
+// origins of the result refer to the files each line came from.
+func preprocessShader(source ShaderSource, files definitionFiles, includePaths []string, defines map[string]string) (result ShaderSource, err error) {
+	preprocessor := shaderPreprocessor{files: files, includePaths: includePaths, included: make(map[string]bool)}
+	if source.File != "" {
+		preprocessor.including = []string{source.File}
+		preprocessor.included[source.File] = true
+	}
+	if err = preprocessor.add(source); err != nil {
+		return source, err
+	}
+
+	names := make([]string, 0, len(defines))
+	for name := range defines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// defines go after #version, which must come first, or at the top when there isn't one
+	insertAt := 0
+	for i, line := range preprocessor.lines {
+		trimmed := strings.TrimSpace(line)
+		if kVersionPattern.MatchString(line) {
+			insertAt = i + 1
+			break
+		}
+		if trimmed != "" && !strings.HasPrefix(trimmed, "//") {
+			break
+		}
+	}
+
+	lines := append([]string(nil), preprocessor.lines[:insertAt]...)
+	origins := append([]SourceLine(nil), preprocessor.origins[:insertAt]...)
+	for i, name := range names {
+		value := defines[name]
+		if !kDefineNamePattern.MatchString(name) {
+			return source, fmt.Errorf("define %q: not a valid macro name", name)
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return source, fmt.Errorf("define %s: value spans several lines", name)
+		}
+		lines = append(lines, strings.TrimSpace(fmt.Sprintf("#define %s %s", name, value)))
+		origins = append(origins, SourceLine{File: kDefinesFile, Line: i + 1})
+	}
+	lines = append(lines, preprocessor.lines[insertAt:]...)
+	origins = append(origins, preprocessor.origins[insertAt:]...)
+
+	return ShaderSource{Text: strings.Join(lines, "\n"), File: source.File, Origins: origins}, nil
+}
+
+func (preprocessor *shaderPreprocessor) add(source ShaderSource) error {
+	inComment := false
+	for i, line := range strings.Split(source.Text, "\n") {
+		origin := source.Origin(i + 1)
+		var match []string
+		if !inComment {
+			preprocessor.followConditional(line)
+			if !preprocessor.skipping() {
+				match = kIncludePattern.FindStringSubmatch(line)
+			}
+		}
+		inComment = endsInComment(line, inComment)
+		if match == nil {
+			preprocessor.lines = append(preprocessor.lines, line)
+			preprocessor.origins = append(preprocessor.origins, origin)
+			continue
+		}
+
+		included, err := preprocessor.include(source.File, match[1], match[2])
+		if err != nil {
+			return &ShaderError{File: origin.File, Line: origin.Line, Message: err.Error()}
+		}
+		if included.File == "" {
+			continue
+		}
+		preprocessor.including = append(preprocessor.including, included.File)
+		if err = preprocessor.add(included); err != nil {
+			return err
+		}
+		preprocessor.including = preprocessor.including[:len(preprocessor.including)-1]
+	}
+	return nil
+}
+
+// followConditional updates the #if blocks around the current line with the directive on line, if
+// it is one.
+func (preprocessor *shaderPreprocessor) followConditional(line string) {
+	match := kConditionalPattern.FindStringSubmatch(line)
+	if match == nil {
+		return
+	}
+	directive, condition := match[1], match[2]
+	depth := len(preprocessor.conditionals)
+	switch directive {
+	case "if", "ifdef", "ifndef":
+		block := conditionalBlock{}
+		if directive == "if" {
+			block.skipped, block.taken = condition == "0", condition == "1"
+		}
+		preprocessor.conditionals = append(preprocessor.conditionals, block)
+	case "elif":
+		if depth > 0 {
+			block := &preprocessor.conditionals[depth-1]
+			block.skipped = block.taken || condition == "0"
+			block.taken = block.taken || condition == "1"
+		}
+	case "else":
+		if depth > 0 {
+			block := &preprocessor.conditionals[depth-1]
+			block.skipped = block.taken
+		}
+	case "endif":
+		// the compiler reports an unmatched #endif
+		if depth > 0 {
+			preprocessor.conditionals = preprocessor.conditionals[:depth-1]
+		}
+	}
+}
+
+// skipping reports whether the current line is known not to be compiled.
+func (preprocessor *shaderPreprocessor) skipping() bool {
+	for _, block := range preprocessor.conditionals {
+		if block.skipped {
+			return true
+		}
+	}
+	return false
+}
+
+// endsInComment reports whether a block comment is still open at the end of line, given whether
+// one was open at its start.
+func endsInComment(line string, inComment bool) bool {
+	for i := 0; i+1 < len(line); i++ {
+		switch {
+		case inComment && line[i:i+2] == "*/":
+			inComment = false
+			i++
+		case !inComment && line[i:i+2] == "/*":
+			inComment = true
+			i++
+		case !inComment && line[i:i+2] == "//":
+			return false
+		}
+	}
+	return inComment
+}
+
+// include loads the file named by an #include directive in includingFile, quotedName for
+// #include "name" and bracketedName for #include <name>. The result has no File when the file was
+// already expanded.
+func (preprocessor *shaderPreprocessor) include(includingFile string, quotedName string, bracketedName string) (included ShaderSource, err error) {
+	name := quotedName + bracketedName
+
+	var candidates []string
+	if quotedName != "" && includingFile != "" {
+		candidates = append(candidates, preprocessor.files.beside(includingFile).resolve(name))
+	}
+	for _, includePath := range preprocessor.includePaths {
+		includeFiles := definitionFiles{fsys: preprocessor.files.fsys, dir: includePath}
+		candidates = append(candidates, includeFiles.resolve(name))
+	}
+
+	for _, candidate := range candidates {
+		if info, err := preprocessor.files.stat(candidate); err != nil || info.IsDir() {
+			continue
+		}
+		for _, file := range preprocessor.including {
+			if file == candidate {
+				return included, fmt.Errorf("%s is included recursively", candidate)
+			}
+		}
+		if preprocessor.included[candidate] {
+			return included, nil
+		}
+		preprocessor.included[candidate] = true
+
+		text, err := preprocessor.files.loadFragmentShader(candidate)
+		if err != nil {
+			return included, err
+		}
+		return ShaderSource{Text: strings.TrimSuffix(text, "\n"), File: candidate}, nil
+	}
+	return included, fmt.Errorf("cannot find include file %q", name)
+}
@@ -0,0 +1,132 @@
+package glslfilter
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestPreprocessShader(t *testing.T) {
+	fsys := fstest.MapFS{
+		"main.frag":           {Data: []byte("#version 330\n#include \"a.glsl\"\n#include <b.glsl>\nvoid main() {}\n")},
+		"a.glsl":              {Data: []byte("#include <common.glsl>\nfloat a;\n")},
+		"include/b.glsl":      {Data: []byte("#include \"common.glsl\"\nfloat b;\n")},
+		"include/common.glsl": {Data: []byte("float common;\n")},
+		"skipped.frag": {Data: []byte(`#version 330
+#if 0
+#include "missing.glsl"
+#elif 1
+#include "a.glsl"
+#else
+#include "missing.glsl"
+#endif
+/*
+#include "missing.glsl"
+*/ #include "missing.glsl"
+#ifdef X
+#include <b.glsl>
+#endif`)},
+	}
+	files := definitionFiles{fsys: fsys, dir: "."}
+	includePaths := []string{"include"}
+
+	tests := []struct {
+		file    string
+		defines map[string]string
+		lines   []string
+		origins []SourceLine
+	}{
+		{
+			// a.glsl and b.glsl both include common.glsl, which is only expanded the first time
+			file:    "main.frag",
+			defines: map[string]string{"SCALE": "2", "FAST": ""},
+			lines:   []string{"#version 330", "#define FAST", "#define SCALE 2", "float common;", "float a;", "float b;", "void main() {}"},
+			origins: []SourceLine{
+				{"main.frag", 1}, {kDefinesFile, 1}, {kDefinesFile, 2}, {"include/common.glsl", 1},
+				{"a.glsl", 2}, {"include/b.glsl", 2}, {"main.frag", 4},
+			},
+		},
+		{
+			file: "skipped.frag",
+			lines: []string{
+				"#version 330", "#if 0", `#include "missing.glsl"`, "#elif 1", "float common;", "float a;", "#else",
+				`#include "missing.glsl"`, "#endif", "/*", `#include "missing.glsl"`, `*/ #include "missing.glsl"`,
+				"#ifdef X", "float b;", "#endif",
+			},
+			origins: []SourceLine{
+				{"skipped.frag", 1}, {"skipped.frag", 2}, {"skipped.frag", 3}, {"skipped.frag", 4},
+				{"include/common.glsl", 1}, {"a.glsl", 2}, {"skipped.frag", 6}, {"skipped.frag", 7},
+				{"skipped.frag", 8}, {"skipped.frag", 9}, {"skipped.frag", 10}, {"skipped.frag", 11},
+				{"skipped.frag", 12}, {"include/b.glsl", 2}, {"skipped.frag", 14},
+			},
+		},
+	}
+	for _, test := range tests {
+		text, err := files.loadFragmentShader(test.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := preprocessShader(ShaderSource{Text: text, File: test.file}, files, includePaths, test.defines)
+		if err != nil {
+			t.Errorf("%s: %v", test.file, err)
+			continue
+		}
+		if lines := strings.Split(strings.TrimSuffix(result.Text, "\n"), "\n"); !reflect.DeepEqual(lines, test.lines) {
+			t.Errorf("%s: got lines\n%q\nwant\n%q", test.file, lines, test.lines)
+		}
+		if !reflect.DeepEqual(result.Origins[:len(test.origins)], test.origins) {
+			t.Errorf("%s: got origins %v, want %v", test.file, result.Origins, test.origins)
+		}
+	}
+}
+
+func TestPreprocessShaderErrors(t *testing.T) {
+	fsys := fstest.MapFS{
+		"cycle.frag": {Data: []byte("#include \"cycle.glsl\"\n")},
+		"cycle.glsl": {Data: []byte("float c;\n#include \"cycle.frag\"\n")},
+	}
+	files := definitionFiles{fsys: fsys, dir: "."}
+
+	tests := []struct {
+		source  ShaderSource
+		defines map[string]string
+		err     error
+	}{
+		{
+			source: ShaderSource{Text: "#include \"cycle.glsl\"", File: "cycle.frag"},
+			err:    &ShaderError{File: "cycle.glsl", Line: 2, Message: "cycle.frag is included recursively"},
+		},
+		{
+			source: ShaderSource{Text: "#version 330\n\n#include <missing.glsl>", File: "bad.frag"},
+			err:    &ShaderError{File: "bad.frag", Line: 3, Message: `cannot find include file "missing.glsl"`},
+		},
+		{
+			// inline sources have no directory to look in
+			source: ShaderSource{Text: "#include \"cycle.glsl\""},
+			err:    &ShaderError{Line: 1, Message: `cannot find include file "cycle.glsl"`},
+		},
+		{
+			source:  ShaderSource{Text: "#version 330"},
+			defines: map[string]string{"2X": "1"},
+			err:     errors.New(`define "2X": not a valid macro name`),
+		},
+		{
+			source:  ShaderSource{Text: "#version 330"},
+			defines: map[string]string{"X": "1\n2"},
+			err:     errors.New("define X: value spans several lines"),
+		},
+	}
+	for _, test := range tests {
+		_, err := preprocessShader(test.source, files, nil, test.defines)
+		if err == nil || err.Error() != test.err.Error() {
+			t.Errorf("%q: got error %v, want %v", test.source.Text, err, test.err)
+			continue
+		}
+		var shaderErr *ShaderError
+		if wantShaderErr, ok := test.err.(*ShaderError); ok && (!errors.As(err, &shaderErr) || *shaderErr != *wantShaderErr) {
+			t.Errorf("%q: got %#v, want %#v", test.source.Text, err, wantShaderErr)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package glslfilter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ShaderSource is shader source along with where its lines came from, so that compile errors can
+// be reported against the files that were written rather than the text the driver saw.
+type ShaderSource struct {
+	Text string
+	// File is the file Text was loaded from, empty for inline sources.
+	File string
+	// Origins holds the origin of each line of Text when it was assembled from several places.
+	// When it's empty, line n of Text is line n of File.
+	Origins []SourceLine
+}
+
+// SourceLine is a 1-based line of a file.
+type SourceLine struct {
+	File string
+	Line int
+}
+
+// Origin returns where a 1-based line of Text came from.
+func (source ShaderSource) Origin(line int) SourceLine {
+	if len(source.Origins) == 0 {
+		return SourceLine{File: source.File, Line: line}
+	}
+	if line < 1 || line > len(source.Origins) {
+		return SourceLine{File: source.File}
+	}
+	return source.Origins[line-1]
+}
+
+// ShaderError is an error from a shader compiler's info log, mapped back to its origin. Line and
+// Column are 0 when the log didn't give them.
+type ShaderError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+	// Context is an excerpt of the source around the line, as compiled.
+	Context string
+}
+
+func (err *ShaderError) Error() string {
+	file := err.File
+	if file == "" {
+		file = "<source>"
+	}
+
+	var location string
+	switch {
+	case err.Line == 0:
+		location = file
+	case err.Column == 0:
+		location = fmt.Sprintf("%s:%d", file, err.Line)
+	default:
+		location = fmt.Sprintf("%s:%d:%d", file, err.Line, err.Column)
+	}
+	if err.Context == "" {
+		return fmt.Sprintf("%s: %s", location, err.Message)
+	}
+	return fmt.Sprintf("%s: %s\n%s", location, err.Message, err.Context)
+}
+
+// ShaderErrors are the errors of a failed shader compile.
+type ShaderErrors []*ShaderError
+
+func (errs ShaderErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return "failed to compile shader:\n" + strings.Join(messages, "\n")
+}
+
+const kShaderErrorContextLines = 2
+
+// Info log formats of common drivers, each matching the source string, line, column, and message
+// where the driver gives them.
+var kShaderLogPatterns = []struct {
+	pattern                           *regexp.Regexp
+	lineGroup, columnGroup, textGroup int
+}{
+	// Mesa: 0:17(24): error: message
+	{regexp.MustCompile(`^\d+:(\d+)\((\d+)\): error: (.*)$`), 1, 2, 3},
+	// NVIDIA: 0(17) : error C0000: message
+	{regexp.MustCompile(`^\d+\((\d+)\) : error (?:\w+: )?(.*)$`), 1, 0, 2},
+	// AMD, Intel and Apple: ERROR: 0:17: message
+	{regexp.MustCompile(`^ERROR: \d+:(\d+): (.*)$`), 1, 0, 2},
+}
+
+// kShaderLogSummaryPattern matches lines that only count the errors before them.
+var kShaderLogSummaryPattern = regexp.MustCompile(`^ERROR: \d+ compilation errors?\.`)
+
+// parseShaderLog turns an info log into errors mapped back to the origins of source. Lines that
+// aren't in a known format are kept as errors without a location, unless other errors were found.
+func parseShaderLog(infoLog string, source ShaderSource) (errs ShaderErrors) {
+	textLines := strings.Split(source.Text, "\n")
+	var unrecognized ShaderErrors
+
+	for _, logLine := range strings.Split(infoLog, "\n") {
+		logLine = strings.TrimRight(logLine, "\x00\r\t ")
+		if logLine == "" || kShaderLogSummaryPattern.MatchString(logLine) {
+			continue
+		}
+
+		recognized := false
+		for _, format := range kShaderLogPatterns {
+			match := format.pattern.FindStringSubmatch(logLine)
+			if match == nil {
+				continue
+			}
+			recognized = true
+
+			line, _ := strconv.Atoi(match[format.lineGroup])
+			column := 0
+			if format.columnGroup != 0 {
+				column, _ = strconv.Atoi(match[format.columnGroup])
+			}
+			origin := source.Origin(line)
+			errs = append(errs, &ShaderError{
+				File:    origin.File,
+				Line:    origin.Line,
+				Column:  column,
+				Message: match[format.textGroup],
+				Context: sourceExcerpt(textLines, source, line, column),
+			})
+			break
+		}
+		if !recognized && !strings.Contains(logLine, "warning") && !strings.HasPrefix(logLine, "WARNING") {
+			unrecognized = append(unrecognized, &ShaderError{File: source.File, Message: logLine})
+		}
+	}
+
+	if len(errs) == 0 {
+		errs = unrecognized
+	}
+	if len(errs) == 0 {
+		errs = ShaderErrors{{File: source.File, Message: "compilation failed without an info log"}}
+	}
+	return errs
+}
+
+// sourceExcerpt returns the lines of the compiled text around a 1-based line that come from the
+// same file, numbered by their origin, with the line itself marked.
+func sourceExcerpt(textLines []string, source ShaderSource, line int, column int) string {
+	if line < 1 || line > len(textLines) {
+		return ""
+	}
+
+	// neighbouring lines from other files, e.g. around an include, would be misleading
+	file := source.Origin(line).File
+	first, last := line, line
+	for first > 1 && line-first < kShaderErrorContextLines && source.Origin(first-1).File == file {
+		first--
+	}
+	for last < len(textLines) && last-line < kShaderErrorContextLines && source.Origin(last+1).File == file {
+		last++
+	}
+
+	var excerpt strings.Builder
+	for i := first; i <= last; i++ {
+		marker := " "
+		if i == line {
+			marker = ">"
+		}
+		prefix := fmt.Sprintf("%s %4d | ", marker, source.Origin(i).Line)
+		fmt.Fprintf(&excerpt, "%s%s\n", prefix, textLines[i-1])
+		if i == line && column > 0 && column <= len(textLines[i-1])+1 {
+			// tabs are kept so the caret lines up however they're displayed
+			padding := strings.Map(func(r rune) rune {
+				if r == '\t' {
+					return r
+				}
+				return ' '
+			}, prefix+textLines[i-1][:column-1])
+			fmt.Fprintf(&excerpt, "%s^\n", padding)
+		}
+	}
+	return strings.TrimSuffix(excerpt.String(), "\n")
+}
@@ -0,0 +1,106 @@
+package glslfilter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseShaderLog(t *testing.T) {
+	// main.frag with a define after its #version and a.glsl included, which includes common.glsl
+	source := ShaderSource{
+		Text: strings.Join([]string{
+			"#version 330",
+			"#define SCALE 2",
+			"float common;",
+			"float a = b;",
+			"\tvec2 uv = x;",
+			"void main() {}",
+		}, "\n"),
+		File: "main.frag",
+		Origins: []SourceLine{
+			{"main.frag", 1}, {kDefinesFile, 1}, {"common.glsl", 1}, {"a.glsl", 2}, {"main.frag", 3}, {"main.frag", 4},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		infoLog string
+		errs    ShaderErrors
+	}{
+		{
+			name:    "mesa",
+			infoLog: "0:4(11): error: `b' undeclared\n0:5(7): error: `x' undeclared\n0:5(1): warning: unused\n",
+			errs: ShaderErrors{
+				{File: "a.glsl", Line: 2, Column: 11, Message: "`b' undeclared", Context: ">    2 | float a = b;\n" + strings.Repeat(" ", 19) + "^"},
+				{File: "main.frag", Line: 3, Column: 7, Message: "`x' undeclared", Context: ">    3 | \tvec2 uv = x;\n" + strings.Repeat(" ", 9) + "\t     ^\n     4 | void main() {}"},
+			},
+		},
+		{
+			name:    "nvidia",
+			infoLog: "0(2) : error C0000: syntax error, unexpected integer constant\n0(6) : error C1008: undefined variable \"x\"\x00",
+			errs: ShaderErrors{
+				{File: kDefinesFile, Line: 1, Message: "syntax error, unexpected integer constant", Context: ">    1 | #define SCALE 2"},
+				{File: "main.frag", Line: 4, Message: `undefined variable "x"`, Context: "     3 | \tvec2 uv = x;\n>    4 | void main() {}"},
+			},
+		},
+		{
+			name:    "amd",
+			infoLog: "WARNING: 0:1: extension not supported\r\nERROR: 0:3: 'common' : redefinition\r\nERROR: 1 compilation errors.  No code generated.\r\n",
+			errs: ShaderErrors{
+				{File: "common.glsl", Line: 1, Message: "'common' : redefinition", Context: ">    1 | float common;"},
+			},
+		},
+		{
+			name:    "line outside the source",
+			infoLog: "0:40(2): error: unexpected end of file",
+			errs: ShaderErrors{
+				{File: "main.frag", Column: 2, Message: "unexpected end of file"},
+			},
+		},
+		{
+			name:    "unrecognized",
+			infoLog: "Internal error: something went wrong\nwarning: ignored",
+			errs: ShaderErrors{
+				{File: "main.frag", Message: "Internal error: something went wrong"},
+			},
+		},
+		{
+			name:    "unrecognized lines are dropped when others are recognized",
+			infoLog: "Internal error: something went wrong\nERROR: 0:6: 'main' : function already has a body",
+			errs: ShaderErrors{
+				{File: "main.frag", Line: 4, Message: "'main' : function already has a body", Context: "     3 | \tvec2 uv = x;\n>    4 | void main() {}"},
+			},
+		},
+		{
+			name: "empty",
+			errs: ShaderErrors{
+				{File: "main.frag", Message: "compilation failed without an info log"},
+			},
+		},
+	}
+	for _, test := range tests {
+		errs := parseShaderLog(test.infoLog, source)
+		if !reflect.DeepEqual(errs, test.errs) {
+			t.Errorf("%s: got\n%v\nwant\n%v", test.name, errs, test.errs)
+		}
+	}
+}
+
+func TestParseShaderLogWithoutOrigins(t *testing.T) {
+	source := ShaderSource{Text: "#version 330\nvoid main() {\n\tgl_FragColor = 1;\n}", File: "stage.frag"}
+	errs := parseShaderLog("0:3(2): error: `gl_FragColor' undeclared", source)
+	want := ShaderErrors{{
+		File:    "stage.frag",
+		Line:    3,
+		Column:  2,
+		Message: "`gl_FragColor' undeclared",
+		Context: "     1 | #version 330\n     2 | void main() {\n>    3 | \tgl_FragColor = 1;\n" + strings.Repeat(" ", 9) + "\t^\n     4 | }",
+	}}
+	if !reflect.DeepEqual(errs, want) {
+		t.Errorf("got\n%v\nwant\n%v", errs, want)
+	}
+	if message := errs[0].Error(); !strings.HasPrefix(message, "stage.frag:3:2: `gl_FragColor' undeclared\n") {
+		t.Errorf("unexpected message %q", message)
+	}
+}
@@ -3,13 +3,16 @@ package glslfilter
 import (
 	"fmt"
 	"image"
+	"math"
+	"reflect"
 	"strings"
+	"unsafe"
 
 	"github.com/go-gl/gl/v3.3-core/gl"
 )
 
 type Texture struct {
-	Data        *image.RGBA
+	Data        image.Image
 	BindingName string
 	Filter      int32
 }
@@ -17,75 +20,266 @@ type Texture struct {
 type Uniform struct {
 	Type  UniformType
 	Value interface{}
+	// RowMajor is set when the elements of matrices in Value are in row-major order.
+	RowMajor bool
+}
+
+// StageInput binds the named output of another stage to a sampler uniform.
+type StageInput struct {
+	Target      string
+	BindingName string `yaml:"name"`
+	Filter      TextureFilterType
+}
+
+// TargetSize describes the size of a stage's output. Width and Height are absolute; when omitted
+// the size is Scale times the render size, or the size of the RelativeTo output.
+type TargetSize struct {
+	Width      int
+	Height     int
+	Scale      float64
+	RelativeTo string `yaml:"relativeTo"`
 }
 
 type FilterStage struct {
-	program  uint32
-	textures map[string]uint32
-	uniforms map[string]*Uniform
+	// Output names the render target this stage draws to, which later stages can bind through
+	// Inputs. It defaults to stageN after the stage's index, e.g. stage0. The following stage can
+	// also read the result as previousResult.
+	Output string
+	Inputs []StageInput
+	Size   TargetSize
+	Format TargetFormat
+
+	program        uint32
+	textures       map[string]*stageTexture
+	uniforms       map[string]*Uniform
+	activeUniforms map[string]*ShaderUniform
 }
 
+type stageTexture struct {
+	name           uint32
+	size           image.Point
+	internalFormat uint32
+	filter         int32
+}
+
+// NewFilterStage compiles a stage and uploads its textures. Uniform values that don't match their
+// type or the shader's declaration are reported as a *UniformError.
 func NewFilterStage(fragmentShaderSource string, textures []Texture, uniformDefinitions []UniformDefinition) (stage *FilterStage, err error) {
+	return NewFilterStageWithSource(ShaderSource{Text: fragmentShaderSource}, textures, uniformDefinitions)
+}
+
+// NewFilterStageWithSource is NewFilterStage for a shader whose compile errors, returned as
+// ShaderErrors, should refer to the files it came from.
+func NewFilterStageWithSource(fragmentShaderSource ShaderSource, textures []Texture, uniformDefinitions []UniformDefinition) (stage *FilterStage, err error) {
 	stage = new(FilterStage)
-	stage.textures = make(map[string]uint32)
+	stage.textures = make(map[string]*stageTexture)
 	stage.uniforms = make(map[string]*Uniform)
 
+	// uniform values are checked first so a bad value doesn't leave GL objects behind
+	for _, uniformDefinition := range uniformDefinitions {
+		uniform := Uniform{
+			Type:     uniformDefinition.Type,
+			RowMajor: uniformDefinition.RowMajor,
+		}
+		if uniform.Value, err = normalizeUniformValue(uniformDefinition); err != nil {
+			return nil, err
+		}
+		if uniform.Type.ScalarType == Double && !hasDoublePrecision() {
+			return nil, &UniformError{Stage: -1, Name: uniformDefinition.Name, Reason: "double uniforms need OpenGL 4.0 or GL_ARB_gpu_shader_fp64"}
+		}
+		stage.uniforms[uniformDefinition.Name] = &uniform
+	}
+
 	stage.program, err = newProgram(fragmentShaderSource)
 	if err != nil {
 		return nil, err
 	}
+	stage.activeUniforms = reflectUniforms(stage.program)
+	if err = stage.checkUniforms(uniformDefinitions); err != nil {
+		stage.Delete()
+		return nil, err
+	}
 
 	if !hasEnoughTextureUnits(len(textures) + 1) {
+		stage.Delete()
 		return nil, fmt.Errorf("more textures defined than available texture units")
 	}
 
 	for _, texture := range textures {
-		textureName := createTexture(texture.Data, texture.Filter)
-		stage.textures[texture.BindingName] = textureName
+		stage.textures[texture.BindingName] = createTexture(texture.Data, texture.Filter)
 	}
 
-	for _, uniformDefinition := range uniformDefinitions {
-		uniform := Uniform{
-			Type: uniformDefinition.Type,
+	return stage, err
+}
+
+// Delete frees the stage's program and textures. Stages passed to Engine.Init or
+// Engine.SetStages are deleted by the engine.
+func (stage *FilterStage) Delete() {
+	deleteProgramObject(stage.program)
+	stage.program = 0
+	for bindingName, texture := range stage.textures {
+		deleteTextureObject(texture.name)
+		delete(stage.textures, bindingName)
+	}
+}
+
+// HasTexture reports whether the stage was created with a texture bound to bindingName.
+func (stage *FilterStage) HasTexture(bindingName string) bool {
+	_, exists := stage.textures[bindingName]
+	return exists
+}
+
+// SetTexture replaces the data of one of the stage's textures. The existing texture is updated
+// in place when the size and precision match, otherwise it is recreated with the same filter.
+func (stage *FilterStage) SetTexture(bindingName string, data image.Image) error {
+	texture, exists := stage.textures[bindingName]
+	if !exists {
+		return fmt.Errorf("stage has no texture named %s", bindingName)
+	}
+
+	size := data.Bounds().Size()
+	internalFormat, pixelType, pixels := textureUploadData(data)
+	if size == texture.size && internalFormat == texture.internalFormat {
+		gl.TextureSubImage2D(texture.name, 0, 0, 0, int32(size.X), int32(size.Y), gl.RGBA, pixelType, pixels)
+		return nil
+	}
+
+	deleteTextureObject(texture.name)
+	stage.textures[bindingName] = createTexture(data, texture.filter)
+	return nil
+}
+
+// SetUniform changes the value of a uniform for the following renders. The value takes the same
+// forms as in a definition, or the one Uniforms returns, and must match the uniform's type: the one
+// in the definition, or the shader's declaration for uniforms the definition doesn't set. Built-in
+// uniforms can only be changed when the definition sets them. Errors are *UniformError.
+func (stage *FilterStage) SetUniform(name string, value interface{}) error {
+	uniformDefinition := UniformDefinition{Name: name, Value: value}
+	uniform, defined := stage.uniforms[name]
+	if !defined && stage.isEngineUniform(name) {
+		return &UniformError{Stage: -1, Name: name, Reason: "the engine sets it"}
+	}
+	shaderUniform, active := stage.activeUniforms[name]
+	switch {
+	case defined:
+		uniformDefinition.Type = uniform.Type
+		uniformDefinition.RowMajor = uniform.RowMajor
+	case active && !shaderUniform.Sampler && shaderUniform.Type.ScalarType != 0:
+		uniformDefinition.Type = shaderUniform.Type
+	default:
+		return &UniformError{Stage: -1, Name: name, Reason: "the stage has no such uniform"}
+	}
+
+	normed, err := normalizeUniformValue(uniformDefinition)
+	if err != nil {
+		return err
+	}
+	if active {
+		if err = shaderUniform.check(uniformDefinition, normed); err != nil {
+			return err
 		}
-		stage.uniforms[uniformDefinition.Name] = &uniform
-		uniform.Value = normalizeUniformValue(uniformDefinition)
+		shaderUniform.Value = normed
 	}
+	stage.uniforms[name] = &Uniform{Type: uniformDefinition.Type, Value: normed, RowMajor: uniformDefinition.RowMajor}
+	return nil
+}
 
-	return stage, err
+// check returns an error naming the first of width, height and scale that's negative.
+func (size TargetSize) check() (key string, err error) {
+	switch {
+	case size.Width < 0:
+		return "width", fmt.Errorf("width must not be negative, got %d", size.Width)
+	case size.Height < 0:
+		return "height", fmt.Errorf("height must not be negative, got %d", size.Height)
+	case size.Scale < 0:
+		return "scale", fmt.Errorf("scale must not be negative, got %g", size.Scale)
+	}
+	return "", nil
+}
+
+func (size TargetSize) resolve(base image.Point) image.Point {
+	scale := size.Scale
+	if scale == 0 {
+		scale = 1
+	}
+
+	resolved := image.Pt(int(math.Round(float64(base.X)*scale)), int(math.Round(float64(base.Y)*scale)))
+	if size.Width > 0 {
+		resolved.X = size.Width
+	}
+	if size.Height > 0 {
+		resolved.Y = size.Height
+	}
+	if resolved.X < 1 {
+		resolved.X = 1
+	}
+	if resolved.Y < 1 {
+		resolved.Y = 1
+	}
+	return resolved
 }
 
-func createTexture(texture *image.RGBA, filter int32) (texName uint32) {
-	width := texture.Rect.Dx()
-	height := texture.Rect.Dy()
+func createTexture(texture image.Image, filter int32) *stageTexture {
+	width := texture.Bounds().Dx()
+	height := texture.Bounds().Dy()
 
 	if filter == 0 {
 		filter = gl.LINEAR
 	}
 
-	gl.CreateTextures(gl.TEXTURE_2D, 1, &texName)
-	gl.TextureStorage2D(texName, 1, gl.RGBA8, int32(width), int32(height))
-	gl.TextureSubImage2D(texName, 0, 0, 0, int32(width), int32(height), gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(texture.Pix))
+	internalFormat, pixelType, pixels := textureUploadData(texture)
+	texName := createTextureObject()
+	gl.TextureStorage2D(texName, 1, internalFormat, int32(width), int32(height))
+	gl.TextureSubImage2D(texName, 0, 0, 0, int32(width), int32(height), gl.RGBA, pixelType, pixels)
 	gl.TextureParameteri(texName, gl.TEXTURE_MIN_FILTER, filter)
 	gl.TextureParameteri(texName, gl.TEXTURE_MAG_FILTER, filter)
 	gl.TextureParameteri(texName, gl.TEXTURE_WRAP_S, gl.REPEAT)
 	gl.TextureParameteri(texName, gl.TEXTURE_WRAP_T, gl.REPEAT)
 
-	return texName
+	return &stageTexture{texName, image.Pt(width, height), internalFormat, filter}
 }
 
-func newProgram(fragmentShaderSource string) (name uint32, err error) {
-	vertexShader, err := compileShader(vertexShaderSource, gl.VERTEX_SHADER)
+// textureUploadData picks the texture format matching the precision of the image.
+func textureUploadData(texture image.Image) (internalFormat uint32, pixelType uint32, pixels unsafe.Pointer) {
+	width := texture.Bounds().Dx()
+
+	switch texture := texture.(type) {
+	case *image.RGBA:
+		if texture.Stride == width*4 {
+			return gl.RGBA8, gl.UNSIGNED_BYTE, gl.Ptr(texture.Pix)
+		}
+	case *image.RGBA64:
+		if texture.Stride == width*8 {
+			// image.RGBA64 stores big-endian channels, GL expects native-endian shorts
+			shorts := make([]uint16, len(texture.Pix)/2)
+			for i := range shorts {
+				shorts[i] = uint16(texture.Pix[i*2])<<8 | uint16(texture.Pix[i*2+1])
+			}
+			return gl.RGBA16, gl.UNSIGNED_SHORT, gl.Ptr(shorts)
+		}
+	case *FloatImage:
+		if texture.Stride == width*4 {
+			return gl.RGBA32F, gl.FLOAT, gl.Ptr(texture.Pix)
+		}
+	}
+	return textureUploadData(normalizeTextureData(texture))
+}
+
+func newProgram(fragmentShaderSource ShaderSource) (name uint32, err error) {
+	vertexShader, err := compileShader(ShaderSource{Text: vertexShaderSource}, gl.VERTEX_SHADER)
 	if err != nil {
 		return 0, err
 	}
 
+	defer deleteShaderObject(vertexShader)
+
 	fragmentShader, err := compileShader(fragmentShaderSource, gl.FRAGMENT_SHADER)
 	if err != nil {
 		return 0, err
 	}
+	defer deleteShaderObject(fragmentShader)
 
-	program := gl.CreateProgram()
+	program := createProgramObject()
 
 	gl.AttachShader(program, vertexShader)
 	gl.AttachShader(program, fragmentShader)
@@ -100,19 +294,17 @@ func newProgram(fragmentShaderSource string) (name uint32, err error) {
 		log := strings.Repeat("\x00", int(logLength+1))
 		gl.GetProgramInfoLog(program, logLength, nil, gl.Str(log))
 
+		deleteProgramObject(program)
 		return 0, fmt.Errorf("failed to link program: %v", log)
 	}
 
-	gl.DeleteShader(vertexShader)
-	gl.DeleteShader(fragmentShader)
-
 	return program, nil
 }
 
-func compileShader(source string, shaderType uint32) (name uint32, err error) {
-	shader := gl.CreateShader(shaderType)
+func compileShader(source ShaderSource, shaderType uint32) (name uint32, err error) {
+	shader := createShaderObject(shaderType)
 
-	csources, free := gl.Strs(source + "\x00")
+	csources, free := gl.Strs(source.Text + "\x00")
 	gl.ShaderSource(shader, 1, csources, nil)
 	free()
 	gl.CompileShader(shader)
@@ -126,144 +318,158 @@ func compileShader(source string, shaderType uint32) (name uint32, err error) {
 		log := strings.Repeat("\x00", int(logLength+1))
 		gl.GetShaderInfoLog(shader, logLength, nil, gl.Str(log))
 
-		return 0, fmt.Errorf("failed to compile %v: %v", source, log)
+		deleteShaderObject(shader)
+		return 0, parseShaderLog(log, source)
 	}
 
 	return shader, nil
 }
 
 func layoutNotFoundError(layoutName string, bindingName string) error {
-	return fmt.Errorf("location for %s not found", bindingName)
+	return fmt.Errorf("%s for %s not found: the shader doesn't declare it, or doesn't use it and it was optimized out", layoutName, bindingName)
+}
+
+func (stage *FilterStage) hasUniform(bindingName string) bool {
+	return gl.GetUniformLocation(stage.program, gl.Str(bindingName+"\x00")) != kGLLocationNotFound
 }
 
 func (stage *FilterStage) bindDefinitionTextures() error {
 	for bindingName, texture := range stage.textures {
-		location := gl.GetUniformLocation(stage.program, gl.Str(bindingName+"\x00"))
-		if location == kGLLocationNotFound {
-			return layoutNotFoundError("location", bindingName)
-		} else {
-			var binding int32 = kGLLocationNotFound
-			gl.GetUniformiv(stage.program, location, &binding)
-			if binding == kGLLocationNotFound {
-				return layoutNotFoundError("binding", bindingName)
-			} else {
-				gl.BindTextureUnit(uint32(binding), texture)
-			}
+		if err := stage.bindTexture(bindingName, texture.name, 0); err != nil {
+			return err
 		}
+		if err := stage.setResolutionUniform(bindingName, texture.size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bindTexture binds texture to the unit of the sampler uniform bindingName. It's read through
+// sampler, or with its own filtering when sampler is 0.
+func (stage *FilterStage) bindTexture(bindingName string, texture uint32, sampler uint32) error {
+	location := gl.GetUniformLocation(stage.program, gl.Str(bindingName+"\x00"))
+	if location == kGLLocationNotFound {
+		return layoutNotFoundError("location", bindingName)
+	}
+
+	var binding int32 = kGLLocationNotFound
+	gl.GetUniformiv(stage.program, location, &binding)
+	if binding == kGLLocationNotFound {
+		return layoutNotFoundError("binding", bindingName)
 	}
+	gl.BindTextureUnit(uint32(binding), texture)
+	gl.BindSampler(uint32(binding), sampler)
 	return nil
 }
 
 func (stage *FilterStage) bindDefinitionUniforms() error {
 	for bindingName, uniform := range stage.uniforms {
-		switch v := uniform.Value.(type) {
-		case []float32:
-			stage.bindUniform(bindingName, len(v), uniform.Type, v)
-		case []int32:
-			stage.bindUniform(bindingName, len(v), uniform.Type, v)
-		case []uint32:
-			stage.bindUniform(bindingName, len(v), uniform.Type, v)
-		default:
-			return fmt.Errorf("non-normalized uniform value")
+		// uniforms the shader doesn't use were reported when the stage was created
+		shaderUniform, exists := stage.activeUniforms[bindingName]
+		if !exists {
+			continue
+		}
+		if err := setUniform(shaderUniform.Location, uniform.Type, uniform.RowMajor, uniform.Value); err != nil {
+			return fmt.Errorf("uniform %s: %v", bindingName, err)
 		}
 	}
 	return nil
 }
 
-func (stage *FilterStage) bindUniform(bindingName string, length int, typ UniformType, v interface{}) error {
-	location := gl.GetUniformLocation(stage.program, gl.Str(bindingName+"\x00"))
-	if location == kGLLocationNotFound {
-		return layoutNotFoundError("location", bindingName)
+// uniformArrayLength returns the number of elements in a normalized uniform value.
+func uniformArrayLength(uniformType UniformType, value interface{}) int {
+	return reflect.ValueOf(value).Len() / uniformType.components()
+}
+
+var kFloatMatrixSetters = map[[2]int]func(location int32, count int32, transpose bool, value *float32){
+	{2, 2}: gl.UniformMatrix2fv,
+	{2, 3}: gl.UniformMatrix2x3fv,
+	{2, 4}: gl.UniformMatrix2x4fv,
+	{3, 2}: gl.UniformMatrix3x2fv,
+	{3, 3}: gl.UniformMatrix3fv,
+	{3, 4}: gl.UniformMatrix3x4fv,
+	{4, 2}: gl.UniformMatrix4x2fv,
+	{4, 3}: gl.UniformMatrix4x3fv,
+	{4, 4}: gl.UniformMatrix4fv,
+}
+
+var kDoubleMatrixSetters = map[[2]int]func(location int32, count int32, transpose bool, value *float64){
+	{2, 2}: gl.UniformMatrix2dv,
+	{2, 3}: gl.UniformMatrix2x3dv,
+	{2, 4}: gl.UniformMatrix2x4dv,
+	{3, 2}: gl.UniformMatrix3x2dv,
+	{3, 3}: gl.UniformMatrix3dv,
+	{3, 4}: gl.UniformMatrix3x4dv,
+	{4, 2}: gl.UniformMatrix4x2dv,
+	{4, 3}: gl.UniformMatrix4x3dv,
+	{4, 4}: gl.UniformMatrix4dv,
+}
+
+// setUniform sets a uniform to a normalized value. Matrices are column-major unless rowMajor is set.
+func setUniform(location int32, typ UniformType, rowMajor bool, v interface{}) error {
+	count := int32(uniformArrayLength(typ, v))
+	if count == 0 {
+		return nil
 	}
 
-	if err := setUniform(location, int32(length), typ.ScalarType, typ.VectorSize, v); err != nil {
-		return err
+	if typ.Columns > 0 {
+		size := [2]int{typ.Columns, typ.Rows}
+		switch typ.ScalarType {
+		case Float:
+			kFloatMatrixSetters[size](location, count, rowMajor, &v.([]float32)[0])
+		case Double:
+			kDoubleMatrixSetters[size](location, count, rowMajor, &v.([]float64)[0])
+		default:
+			return fmt.Errorf("invalid uniform type specified")
+		}
+		return nil
 	}
-	return nil
-}
 
-func setUniform(location int32, length int32, scalarType ScalarTypeName, vectorSize int, v interface{}) error {
-	switch scalarType {
+	switch typ.ScalarType {
 	case Float:
-		switch vectorSize {
+		switch typ.VectorSize {
 		case 0:
-			gl.Uniform1fv(
-				location,
-				length,
-				&v.([]float32)[0],
-			)
+			gl.Uniform1fv(location, count, &v.([]float32)[0])
 		case 2:
-			gl.Uniform2fv(
-				location,
-				length,
-				&v.([]float32)[0],
-			)
+			gl.Uniform2fv(location, count, &v.([]float32)[0])
 		case 3:
-			gl.Uniform3fv(
-				location,
-				length,
-				&v.([]float32)[0],
-			)
+			gl.Uniform3fv(location, count, &v.([]float32)[0])
 		case 4:
-			gl.Uniform4fv(
-				location,
-				length,
-				&v.([]float32)[0],
-			)
+			gl.Uniform4fv(location, count, &v.([]float32)[0])
 		}
-	case Int:
-		switch vectorSize {
+	case Int, Bool:
+		switch typ.VectorSize {
 		case 0:
-			gl.Uniform1iv(
-				location,
-				length,
-				&v.([]int32)[0],
-			)
+			gl.Uniform1iv(location, count, &v.([]int32)[0])
 		case 2:
-			gl.Uniform2iv(
-				location,
-				length,
-				&v.([]int32)[0],
-			)
+			gl.Uniform2iv(location, count, &v.([]int32)[0])
 		case 3:
-			gl.Uniform3iv(
-				location,
-				length,
-				&v.([]int32)[0],
-			)
+			gl.Uniform3iv(location, count, &v.([]int32)[0])
 		case 4:
-			gl.Uniform4iv(
-				location,
-				length,
-				&v.([]int32)[0],
-			)
+			gl.Uniform4iv(location, count, &v.([]int32)[0])
 		}
 	case Uint:
-		switch vectorSize {
+		switch typ.VectorSize {
+		case 0:
+			gl.Uniform1uiv(location, count, &v.([]uint32)[0])
+		case 2:
+			gl.Uniform2uiv(location, count, &v.([]uint32)[0])
+		case 3:
+			gl.Uniform3uiv(location, count, &v.([]uint32)[0])
+		case 4:
+			gl.Uniform4uiv(location, count, &v.([]uint32)[0])
+		}
+	case Double:
+		switch typ.VectorSize {
 		case 0:
-			gl.Uniform1uiv(
-				location,
-				length,
-				&v.([]uint32)[0],
-			)
+			gl.Uniform1dv(location, count, &v.([]float64)[0])
 		case 2:
-			gl.Uniform2uiv(
-				location,
-				length,
-				&v.([]uint32)[0],
-			)
+			gl.Uniform2dv(location, count, &v.([]float64)[0])
 		case 3:
-			gl.Uniform3uiv(
-				location,
-				length,
-				&v.([]uint32)[0],
-			)
+			gl.Uniform3dv(location, count, &v.([]float64)[0])
 		case 4:
-			gl.Uniform4uiv(
-				location,
-				length,
-				&v.([]uint32)[0],
-			)
+			gl.Uniform4dv(location, count, &v.([]float64)[0])
 		}
 
 	default:
@@ -0,0 +1,53 @@
+package glslfilter
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+	"gopkg.in/yaml.v3"
+)
+
+// TargetFormat is the internal format of a stage's render target. The zero value uses the
+// engine's default format.
+type TargetFormat uint32
+
+const (
+	RGBA8          TargetFormat = gl.RGBA8
+	RGBA16F        TargetFormat = gl.RGBA16F
+	RGBA32F        TargetFormat = gl.RGBA32F
+	R11F_G11F_B10F TargetFormat = gl.R11F_G11F_B10F
+	RG16F          TargetFormat = gl.RG16F
+	R32F           TargetFormat = gl.R32F
+)
+
+var targetFormatNames = map[TargetFormat]string{
+	RGBA8:          "RGBA8",
+	RGBA16F:        "RGBA16F",
+	RGBA32F:        "RGBA32F",
+	R11F_G11F_B10F: "R11F_G11F_B10F",
+	RG16F:          "RG16F",
+	R32F:           "R32F",
+}
+
+func (format TargetFormat) String() string {
+	if name, ok := targetFormatNames[format]; ok {
+		return name
+	}
+	return fmt.Sprintf("TargetFormat(%#x)", uint32(format))
+}
+
+func (format *TargetFormat) UnmarshalYAML(value *yaml.Node) (err error) {
+	var rawString string
+	if err = value.Decode(&rawString); err != nil {
+		return err
+	}
+
+	for candidate, name := range targetFormatNames {
+		if strings.EqualFold(rawString, name) {
+			*format = candidate
+			return nil
+		}
+	}
+	return newDefinitionError(value, "invalid format specified: \"%s\", options are (RGBA8|RGBA16F|RGBA32F|R11F_G11F_B10F|RG16F|R32F)", rawString)
+}
@@ -2,22 +2,81 @@ package glslfilter
 
 import (
 	"fmt"
+	"math"
 	"reflect"
-
-	"github.com/smithjacobj/glslfilter/util"
 )
 
-func normalizeUniformValue(definition UniformDefinition) (normed interface{}) {
-	if definition.Type.IsArray {
-		return normalizeArray(definition.Value, definition.Type)
-	} else {
-		return normalizeValue(definition.Value, definition.Type)
+// UniformError reports a uniform value that doesn't match the uniform's type. Value is the
+// offending value, which may be a single element of an array.
+type UniformError struct {
+	// Stage is the index of the stage in its definition, or -1 when it isn't known.
+	Stage  int
+	Name   string
+	Type   UniformType
+	Value  interface{}
+	Reason string
+}
+
+func (err *UniformError) Error() string {
+	stage := ""
+	if err.Stage >= 0 {
+		stage = fmt.Sprintf("stage %d: ", err.Stage)
+	}
+	if err.Type.ScalarType == 0 {
+		return fmt.Sprintf("%suniform %s: %s", stage, err.Name, err.Reason)
+	}
+	return fmt.Sprintf("%suniform %s: expected %s, got %#v: %s", stage, err.Name, err.Type, err.Value, err.Reason)
+}
+
+func newUniformValueError(v interface{}, format string, args ...interface{}) *UniformError {
+	return &UniformError{Stage: -1, Value: v, Reason: fmt.Sprintf(format, args...)}
+}
+
+func unexpectedTypeError(v interface{}) *UniformError {
+	if v == nil {
+		return newUniformValueError(v, "value is missing")
+	}
+	return newUniformValueError(v, "unexpected type %s", reflect.TypeOf(v))
+}
+
+// normalizeUniformValue converts a uniform value to a []float32, []int32, []uint32 or []float64.
+// Bools become []int32 of 0 and 1, and matrices are flattened in the order they're written.
+// Errors are *UniformError with an unknown stage.
+func normalizeUniformValue(definition UniformDefinition) (normed interface{}, err error) {
+	switch {
+	case definition.Value == nil:
+		err = unexpectedTypeError(nil)
+	case definition.Type.IsArray:
+		normed, err = normalizeArray(definition.Value, definition.Type)
+	default:
+		normed, err = normalizeValue(definition.Value, definition.Type)
+	}
+	if err != nil {
+		uniformErr := err.(*UniformError)
+		uniformErr.Name = definition.Name
+		uniformErr.Type = definition.Type
+		return nil, uniformErr
 	}
+	return normed, nil
 }
 
-func normalizeArray(v interface{}, typ UniformType) (normed interface{}) {
+func normalizeArray(v interface{}, typ UniformType) (normed interface{}, err error) {
 	vValue := reflect.ValueOf(v)
-	util.Invariant(vValue.Kind() == reflect.Slice)
+	if vValue.Kind() != reflect.Slice {
+		return nil, newUniformValueError(v, "arrays must be lists")
+	}
+
+	// a flat list of numbers, as FilterStage.Uniforms returns, holds the components of each element
+	// in turn
+	if kind := vValue.Type().Elem().Kind(); typ.components() > 1 && kind != reflect.Slice && kind != reflect.Array && kind != reflect.Interface {
+		if normed, err = normalizeComponents(v, typ); err != nil {
+			return nil, err
+		}
+		if components := reflect.ValueOf(normed).Len(); components%typ.components() != 0 {
+			return nil, newUniformValueError(v, "expected a multiple of %d components, got %d", typ.components(), components)
+		}
+		return normed, nil
+	}
 
 	length := vValue.Len()
 
@@ -25,203 +84,322 @@ func normalizeArray(v interface{}, typ UniformType) (normed interface{}) {
 	case Float:
 		var floatSlice []float32
 		for i := 0; i < length; i++ {
-			normedAsType := normToFloat(vValue.Index(i).Interface())
-			validateTypeLength(normedAsType, typ)
+			element := vValue.Index(i).Interface()
+			normedAsType, err := normToFloat(element)
+			if err != nil {
+				return nil, err
+			}
+			if err = validateTypeLength(element, normedAsType, typ); err != nil {
+				return nil, err
+			}
 			floatSlice = append(floatSlice, normedAsType...)
 		}
 		normed = floatSlice
 	case Int:
 		var intSlice []int32
 		for i := 0; i < length; i++ {
-			normedAsType := normToInt(vValue.Index(i).Interface())
-			validateTypeLength(normedAsType, typ)
+			element := vValue.Index(i).Interface()
+			normedAsType, err := normToInt(element)
+			if err != nil {
+				return nil, err
+			}
+			if err = validateTypeLength(element, normedAsType, typ); err != nil {
+				return nil, err
+			}
 			intSlice = append(intSlice, normedAsType...)
 		}
 		normed = intSlice
 	case Uint:
 		var uintSlice []uint32
 		for i := 0; i < length; i++ {
-			normedAsType := normToUint(vValue.Index(i).Interface())
-			validateTypeLength(normedAsType, typ)
+			element := vValue.Index(i).Interface()
+			normedAsType, err := normToUint(element)
+			if err != nil {
+				return nil, err
+			}
+			if err = validateTypeLength(element, normedAsType, typ); err != nil {
+				return nil, err
+			}
 			uintSlice = append(uintSlice, normedAsType...)
 		}
 		normed = uintSlice
+	case Bool:
+		var boolSlice []int32
+		for i := 0; i < length; i++ {
+			element := vValue.Index(i).Interface()
+			normedAsType, err := normToBool(element)
+			if err != nil {
+				return nil, err
+			}
+			if err = validateTypeLength(element, normedAsType, typ); err != nil {
+				return nil, err
+			}
+			boolSlice = append(boolSlice, normedAsType...)
+		}
+		normed = boolSlice
+	case Double:
+		var doubleSlice []float64
+		for i := 0; i < length; i++ {
+			element := vValue.Index(i).Interface()
+			normedAsType, err := normToDouble(element)
+			if err != nil {
+				return nil, err
+			}
+			if err = validateTypeLength(element, normedAsType, typ); err != nil {
+				return nil, err
+			}
+			doubleSlice = append(doubleSlice, normedAsType...)
+		}
+		normed = doubleSlice
 	default:
-		panic("normalizeArray: invalid type specified")
+		return nil, newUniformValueError(v, "type is missing or invalid")
+	}
+	return normed, nil
+}
+
+func normalizeValue(v interface{}, typ UniformType) (normed interface{}, err error) {
+	if normed, err = normalizeComponents(v, typ); err != nil {
+		return nil, err
 	}
-	return normed
+	if err = validateTypeLength(v, normed, typ); err != nil {
+		return nil, err
+	}
+	return normed, nil
 }
 
-func normalizeValue(v interface{}, typ UniformType) (normed interface{}) {
+// normalizeComponents converts every component in v to the scalar type of typ.
+func normalizeComponents(v interface{}, typ UniformType) (normed interface{}, err error) {
 	switch typ.ScalarType {
 	case Float:
-		normed = normToFloat(v)
+		normed, err = normToFloat(v)
 	case Int:
-		normed = normToInt(v)
+		normed, err = normToInt(v)
 	case Uint:
-		normed = normToUint(v)
+		normed, err = normToUint(v)
+	case Bool:
+		normed, err = normToBool(v)
+	case Double:
+		normed, err = normToDouble(v)
 	default:
-		panic("normalizeValue: invalid type specified")
+		return nil, newUniformValueError(v, "type is missing or invalid")
+	}
+	if err != nil {
+		return nil, err
 	}
-	validateTypeLength(normed, typ)
-	return normed
+	return normed, nil
 }
 
-func normToFloat(v interface{}) (normed []float32) {
+func normToFloat(v interface{}) (normed []float32, err error) {
 	switch v := v.(type) {
 	case float32:
-		return []float32{v}
+		return []float32{v}, nil
 	case []float32:
-		return v
+		return v, nil
 	case float64:
-		return []float32{float32(v)}
+		return []float32{float32(v)}, nil
 	case []float64:
 		f64Slice := v
 		f32Slice := []float32{}
 		for _, v := range f64Slice {
 			f32Slice = append(f32Slice, float32(v))
 		}
-		return f32Slice
+		return f32Slice, nil
 	case int:
-		return []float32{float32(v)}
+		return []float32{float32(v)}, nil
 	case []int:
 		intSlice := v
 		f32Slice := []float32{}
 		for _, v := range intSlice {
 			f32Slice = append(f32Slice, float32(v))
 		}
-		return f32Slice
+		return f32Slice, nil
 	case uint:
-		return []float32{float32(v)}
+		return []float32{float32(v)}, nil
 	case []uint:
 		uintSlice := v
 		f32Slice := []float32{}
 		for _, v := range uintSlice {
 			f32Slice = append(f32Slice, float32(v))
 		}
-		return f32Slice
+		return f32Slice, nil
 	case []interface{}:
 		// special case from reflection
 		interfaceSlice := v
 		f32Slice := []float32{}
 		for _, v := range interfaceSlice {
-			f32Slice = append(f32Slice, normToFloat(v)...)
+			normedElement, err := normToFloat(v)
+			if err != nil {
+				return nil, err
+			}
+			f32Slice = append(f32Slice, normedElement...)
+		}
+		return f32Slice, nil
+	}
+	return nil, unexpectedTypeError(v)
+}
+
+func normToInt(v interface{}) (normed []int32, err error) {
+	values, err := normToDouble(v)
+	if err != nil {
+		return nil, err
+	}
+	normed = make([]int32, len(values))
+	for i, value := range values {
+		switch {
+		case value != math.Trunc(value):
+			return nil, newUniformValueError(value, "not an integer")
+		case value < math.MinInt32 || value > math.MaxInt32:
+			return nil, newUniformValueError(value, "out of range for an int")
+		}
+		normed[i] = int32(value)
+	}
+	return normed, nil
+}
+
+func normToUint(v interface{}) (normed []uint32, err error) {
+	values, err := normToDouble(v)
+	if err != nil {
+		return nil, err
+	}
+	normed = make([]uint32, len(values))
+	for i, value := range values {
+		switch {
+		case value != math.Trunc(value):
+			return nil, newUniformValueError(value, "not an integer")
+		case value < 0:
+			return nil, newUniformValueError(value, "negative values can't be unsigned")
+		case value > math.MaxUint32:
+			return nil, newUniformValueError(value, "out of range for a uint")
 		}
-		return f32Slice
+		normed[i] = uint32(value)
 	}
-	panicMsg := fmt.Sprintf("normToFloat: unexpected type %s in bagging area", reflect.TypeOf(v))
-	panic(panicMsg)
+	return normed, nil
 }
 
-func normToInt(v interface{}) (normed []int32) {
+func normToBool(v interface{}) (normed []int32, err error) {
 	switch v := v.(type) {
-	case float32:
-		return []int32{int32(v)}
-	case []float32:
-		f32Slice := v
-		intSlice := []int32{}
-		for _, v := range f32Slice {
-			intSlice = append(intSlice, int32(v))
+	case bool:
+		if v {
+			return []int32{1}, nil
 		}
-		return intSlice
-	case float64:
-		return []int32{int32(v)}
-	case []float64:
-		f64Slice := v
+		return []int32{0}, nil
+	case []bool:
+		boolSlice := v
 		intSlice := []int32{}
-		for _, v := range f64Slice {
-			intSlice = append(intSlice, int32(v))
-		}
-		return intSlice
-	case int:
-		return []int32{int32(v)}
-	case []int:
-		intSlice := v
-		int32Slice := []int32{}
-		for _, v := range intSlice {
-			int32Slice = append(int32Slice, int32(v))
+		for _, v := range boolSlice {
+			normedElement, _ := normToBool(v)
+			intSlice = append(intSlice, normedElement...)
 		}
-		return int32Slice
-	case uint:
-		return []int32{int32(v)}
-	case []uint:
-		uintSlice := v
+		return intSlice, nil
+	case int32:
+		return normToBool(v != 0)
+	case []int32:
+		// normalized bools, as returned by FilterStage.Uniforms
+		int32Slice := v
 		intSlice := []int32{}
-		for _, v := range uintSlice {
-			intSlice = append(intSlice, int32(v))
+		for _, v := range int32Slice {
+			normedElement, _ := normToBool(v)
+			intSlice = append(intSlice, normedElement...)
 		}
-		return intSlice
+		return intSlice, nil
 	case []interface{}:
 		// special case from reflection
 		interfaceSlice := v
-		int32Slice := []int32{}
+		intSlice := []int32{}
 		for _, v := range interfaceSlice {
-			int32Slice = append(int32Slice, normToInt(v)...)
+			normedElement, err := normToBool(v)
+			if err != nil {
+				return nil, err
+			}
+			intSlice = append(intSlice, normedElement...)
 		}
-		return int32Slice
+		return intSlice, nil
 	}
-	panicMsg := fmt.Sprintf("normToInt: unexpected type %s in bagging area", reflect.TypeOf(v))
-	panic(panicMsg)
+	return nil, unexpectedTypeError(v)
 }
 
-func normToUint(v interface{}) (normed []uint32) {
+func normToDouble(v interface{}) (normed []float64, err error) {
 	switch v := v.(type) {
 	case float32:
-		return []uint32{uint32(v)}
+		return []float64{float64(v)}, nil
 	case []float32:
 		f32Slice := v
-		uintSlice := []uint32{}
+		f64Slice := []float64{}
 		for _, v := range f32Slice {
-			uintSlice = append(uintSlice, uint32(v))
+			f64Slice = append(f64Slice, float64(v))
 		}
-		return uintSlice
+		return f64Slice, nil
 	case float64:
-		return []uint32{uint32(v)}
+		return []float64{v}, nil
 	case []float64:
-		f64Slice := v
-		uintSlice := []uint32{}
-		for _, v := range f64Slice {
-			uintSlice = append(uintSlice, uint32(v))
-		}
-		return uintSlice
+		return v, nil
 	case int:
-		return []uint32{uint32(v)}
+		return []float64{float64(v)}, nil
 	case []int:
 		intSlice := v
-		uintSlice := []uint32{}
+		f64Slice := []float64{}
 		for _, v := range intSlice {
-			uintSlice = append(uintSlice, uint32(v))
+			f64Slice = append(f64Slice, float64(v))
 		}
-		return uintSlice
+		return f64Slice, nil
 	case uint:
-		return []uint32{uint32(v)}
+		return []float64{float64(v)}, nil
 	case []uint:
 		uintSlice := v
-		uint32Slice := []uint32{}
+		f64Slice := []float64{}
 		for _, v := range uintSlice {
-			uint32Slice = append(uint32Slice, uint32(v))
+			f64Slice = append(f64Slice, float64(v))
 		}
-		return uint32Slice
+		return f64Slice, nil
+	case int32:
+		return []float64{float64(v)}, nil
+	case []int32:
+		// normalized int and bool values, as returned by FilterStage.Uniforms
+		int32Slice := v
+		f64Slice := []float64{}
+		for _, v := range int32Slice {
+			f64Slice = append(f64Slice, float64(v))
+		}
+		return f64Slice, nil
+	case uint32:
+		return []float64{float64(v)}, nil
+	case []uint32:
+		uint32Slice := v
+		f64Slice := []float64{}
+		for _, v := range uint32Slice {
+			f64Slice = append(f64Slice, float64(v))
+		}
+		return f64Slice, nil
+	case int64:
+		return []float64{float64(v)}, nil
+	case []int64:
+		int64Slice := v
+		f64Slice := []float64{}
+		for _, v := range int64Slice {
+			f64Slice = append(f64Slice, float64(v))
+		}
+		return f64Slice, nil
 	case []interface{}:
 		// special case from reflection
 		interfaceSlice := v
-		uint32Slice := []uint32{}
+		f64Slice := []float64{}
 		for _, v := range interfaceSlice {
-			uint32Slice = append(uint32Slice, normToUint(v)...)
+			normedElement, err := normToDouble(v)
+			if err != nil {
+				return nil, err
+			}
+			f64Slice = append(f64Slice, normedElement...)
 		}
-		return uint32Slice
+		return f64Slice, nil
 	}
-	panicMsg := fmt.Sprintf("normToUint: unexpected type %s in bagging area", reflect.TypeOf(v))
-	panic(panicMsg)
+	return nil, unexpectedTypeError(v)
 }
 
-func validateTypeLength(v interface{}, typ UniformType) {
-	vValue := reflect.ValueOf(v)
-	correctedSize := typ.VectorSize
-	if correctedSize == 0 {
-		// scalars are 0, but will have a 1-entry slice due to normalization
-		correctedSize = 1
+// validateTypeLength checks that original, normalized to normed, has as many components as typ.
+func validateTypeLength(original interface{}, normed interface{}, typ UniformType) error {
+	vValue := reflect.ValueOf(normed)
+	if vValue.Len() != typ.components() {
+		return newUniformValueError(original, "expected %d components, got %d", typ.components(), vValue.Len())
 	}
-	util.Invariant(vValue.Kind() == reflect.Slice)
-	util.Invariant(vValue.Len() == correctedSize)
+	return nil
 }
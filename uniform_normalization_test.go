@@ -0,0 +1,117 @@
+package glslfilter
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeUniformValue(t *testing.T) {
+	tests := []struct {
+		typ    UniformType
+		value  interface{}
+		normed interface{}
+	}{
+		{UniformType{ScalarType: Float}, 0.5, []float32{0.5}},
+		{UniformType{ScalarType: Int}, 3, []int32{3}},
+		{UniformType{ScalarType: Int}, 2.0, []int32{2}},
+		{UniformType{ScalarType: Int}, -7, []int32{-7}},
+		{UniformType{ScalarType: Uint}, 4294967295.0, []uint32{4294967295}},
+		{UniformType{ScalarType: Uint, VectorSize: 2}, []interface{}{1, 2.0}, []uint32{1, 2}},
+		{UniformType{ScalarType: Int, IsArray: true}, []interface{}{1, -2}, []int32{1, -2}},
+		{UniformType{ScalarType: Bool}, true, []int32{1}},
+		{UniformType{ScalarType: Int, VectorSize: 2}, []int32{1, -2}, []int32{1, -2}},
+		{UniformType{ScalarType: Int}, int64(5), []int32{5}},
+		{UniformType{ScalarType: Int, VectorSize: 3}, []int64{1, 2, 3}, []int32{1, 2, 3}},
+		{UniformType{ScalarType: Uint, VectorSize: 2}, []uint32{1, 4294967295}, []uint32{1, 4294967295}},
+		{UniformType{ScalarType: Uint}, uint32(7), []uint32{7}},
+		{UniformType{ScalarType: Double}, []int32{3}, []float64{3}},
+		{UniformType{ScalarType: Bool, VectorSize: 2}, []int32{0, 2}, []int32{0, 1}},
+		{UniformType{ScalarType: Int, VectorSize: 2, IsArray: true}, []int32{1, 2, 3, 4}, []int32{1, 2, 3, 4}},
+		{UniformType{ScalarType: Float, VectorSize: 2, IsArray: true}, []interface{}{[]float64{1, 2}, []int{3, 4}}, []float32{1, 2, 3, 4}},
+	}
+	for _, test := range tests {
+		normed, err := normalizeUniformValue(UniformDefinition{Name: "u", Type: test.typ, Value: test.value})
+		if err != nil {
+			t.Errorf("%v %v: %v", test.typ, test.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(normed, test.normed) {
+			t.Errorf("%v %v: got %#v, want %#v", test.typ, test.value, normed, test.normed)
+		}
+	}
+}
+
+// TestNormalizeUniformValueRoundTrip checks that normalized values, which FilterStage.Uniforms
+// returns, normalize to themselves so they can be passed back to SetUniform.
+func TestNormalizeUniformValueRoundTrip(t *testing.T) {
+	tests := []struct {
+		typ   UniformType
+		value interface{}
+	}{
+		{UniformType{ScalarType: Float}, 0.5},
+		{UniformType{ScalarType: Float, VectorSize: 3}, []interface{}{1, 2.5, 3}},
+		{UniformType{ScalarType: Float, Columns: 2, Rows: 2}, []interface{}{[]interface{}{1, 2}, []interface{}{3, 4}}},
+		{UniformType{ScalarType: Int}, -3},
+		{UniformType{ScalarType: Int, VectorSize: 4}, []interface{}{1, 2, 3, 4}},
+		{UniformType{ScalarType: Uint, VectorSize: 2}, []interface{}{5, 6}},
+		{UniformType{ScalarType: Bool}, true},
+		{UniformType{ScalarType: Bool, VectorSize: 3}, []interface{}{true, false, true}},
+		{UniformType{ScalarType: Double, VectorSize: 2}, []interface{}{0.25, 8}},
+		{UniformType{ScalarType: Int, IsArray: true}, []interface{}{1, 2, 3}},
+		{UniformType{ScalarType: Uint, VectorSize: 2, IsArray: true}, []interface{}{[]interface{}{1, 2}, []interface{}{3, 4}}},
+		{UniformType{ScalarType: Bool, IsArray: true}, []interface{}{false, true}},
+		{UniformType{ScalarType: Float, VectorSize: 2, IsArray: true}, []interface{}{[]interface{}{1, 2}, []interface{}{3, 4}}},
+	}
+	for _, test := range tests {
+		normed, err := normalizeUniformValue(UniformDefinition{Name: "u", Type: test.typ, Value: test.value})
+		if err != nil {
+			t.Errorf("%v %v: %v", test.typ, test.value, err)
+			continue
+		}
+		again, err := normalizeUniformValue(UniformDefinition{Name: "u", Type: test.typ, Value: normed})
+		if err != nil {
+			t.Errorf("%v %#v: %v", test.typ, normed, err)
+		} else if !reflect.DeepEqual(again, normed) {
+			t.Errorf("%v %#v: normalized again to %#v", test.typ, normed, again)
+		}
+	}
+}
+
+func TestNormalizeUniformValueErrors(t *testing.T) {
+	tests := []struct {
+		typ    UniformType
+		value  interface{}
+		reason string
+	}{
+		{UniformType{ScalarType: Int}, 1.7, "not an integer"},
+		{UniformType{ScalarType: Int}, 3000000000, "out of range for an int"},
+		{UniformType{ScalarType: Uint}, -1, "negative values can't be unsigned"},
+		{UniformType{ScalarType: Uint}, -1.0, "negative values can't be unsigned"},
+		{UniformType{ScalarType: Uint}, 0.25, "not an integer"},
+		{UniformType{ScalarType: Uint}, 4294967296.0, "out of range for a uint"},
+		{UniformType{ScalarType: Int, VectorSize: 2}, []interface{}{1, 2.5}, "not an integer"},
+		{UniformType{ScalarType: Uint, IsArray: true}, []interface{}{1, -1}, "negative values can't be unsigned"},
+		{UniformType{ScalarType: Float}, nil, "value is missing"},
+		{UniformType{ScalarType: Int, IsArray: true}, nil, "value is missing"},
+		{UniformType{ScalarType: Float, IsArray: true}, []interface{}{1.0, nil}, "value is missing"},
+		{UniformType{ScalarType: Int}, "one", "unexpected type string"},
+		{UniformType{ScalarType: Uint}, int32(-1), "negative values can't be unsigned"},
+		{UniformType{ScalarType: Int, VectorSize: 2, IsArray: true}, []int32{1, 2, 3}, "expected a multiple of 2 components, got 3"},
+	}
+	for _, test := range tests {
+		_, err := normalizeUniformValue(UniformDefinition{Name: "u", Type: test.typ, Value: test.value})
+		var uniformErr *UniformError
+		if !errors.As(err, &uniformErr) {
+			t.Errorf("%v %v: got %v, want a *UniformError", test.typ, test.value, err)
+			continue
+		}
+		if uniformErr.Reason != test.reason {
+			t.Errorf("%v %v: got reason %q, want %q", test.typ, test.value, uniformErr.Reason, test.reason)
+		}
+		if uniformErr.Name != "u" || !strings.Contains(err.Error(), test.reason) {
+			t.Errorf("%v %v: unexpected error %q", test.typ, test.value, err)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package glslfilter
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+)
+
+// ShaderUniform describes an active uniform of a stage's linked program. Uniforms the shader
+// declares but doesn't use are removed by the compiler and aren't active.
+type ShaderUniform struct {
+	Name string
+	// Type is the type of the uniform, or of its elements for arrays. It's invalid for samplers and
+	// types that can't be set from a definition.
+	Type UniformType
+	// GLType is the type GL reports, e.g. gl.FLOAT_VEC3 or gl.SAMPLER_2D.
+	GLType  uint32
+	Sampler bool
+	// Size is the number of elements of an array, 1 otherwise.
+	Size     int
+	Location int32
+	// Value is the normalized value set from the definition, nil when it isn't set by one.
+	Value interface{}
+}
+
+var kGLUniformTypes = map[uint32]UniformType{
+	gl.FLOAT:             {ScalarType: Float},
+	gl.FLOAT_VEC2:        {ScalarType: Float, VectorSize: 2},
+	gl.FLOAT_VEC3:        {ScalarType: Float, VectorSize: 3},
+	gl.FLOAT_VEC4:        {ScalarType: Float, VectorSize: 4},
+	gl.INT:               {ScalarType: Int},
+	gl.INT_VEC2:          {ScalarType: Int, VectorSize: 2},
+	gl.INT_VEC3:          {ScalarType: Int, VectorSize: 3},
+	gl.INT_VEC4:          {ScalarType: Int, VectorSize: 4},
+	gl.UNSIGNED_INT:      {ScalarType: Uint},
+	gl.UNSIGNED_INT_VEC2: {ScalarType: Uint, VectorSize: 2},
+	gl.UNSIGNED_INT_VEC3: {ScalarType: Uint, VectorSize: 3},
+	gl.UNSIGNED_INT_VEC4: {ScalarType: Uint, VectorSize: 4},
+	gl.BOOL:              {ScalarType: Bool},
+	gl.BOOL_VEC2:         {ScalarType: Bool, VectorSize: 2},
+	gl.BOOL_VEC3:         {ScalarType: Bool, VectorSize: 3},
+	gl.BOOL_VEC4:         {ScalarType: Bool, VectorSize: 4},
+	gl.DOUBLE:            {ScalarType: Double},
+	gl.DOUBLE_VEC2:       {ScalarType: Double, VectorSize: 2},
+	gl.DOUBLE_VEC3:       {ScalarType: Double, VectorSize: 3},
+	gl.DOUBLE_VEC4:       {ScalarType: Double, VectorSize: 4},
+	gl.FLOAT_MAT2:        {ScalarType: Float, Columns: 2, Rows: 2},
+	gl.FLOAT_MAT2x3:      {ScalarType: Float, Columns: 2, Rows: 3},
+	gl.FLOAT_MAT2x4:      {ScalarType: Float, Columns: 2, Rows: 4},
+	gl.FLOAT_MAT3x2:      {ScalarType: Float, Columns: 3, Rows: 2},
+	gl.FLOAT_MAT3:        {ScalarType: Float, Columns: 3, Rows: 3},
+	gl.FLOAT_MAT3x4:      {ScalarType: Float, Columns: 3, Rows: 4},
+	gl.FLOAT_MAT4x2:      {ScalarType: Float, Columns: 4, Rows: 2},
+	gl.FLOAT_MAT4x3:      {ScalarType: Float, Columns: 4, Rows: 3},
+	gl.FLOAT_MAT4:        {ScalarType: Float, Columns: 4, Rows: 4},
+	gl.DOUBLE_MAT2:       {ScalarType: Double, Columns: 2, Rows: 2},
+	gl.DOUBLE_MAT2x3:     {ScalarType: Double, Columns: 2, Rows: 3},
+	gl.DOUBLE_MAT2x4:     {ScalarType: Double, Columns: 2, Rows: 4},
+	gl.DOUBLE_MAT3x2:     {ScalarType: Double, Columns: 3, Rows: 2},
+	gl.DOUBLE_MAT3:       {ScalarType: Double, Columns: 3, Rows: 3},
+	gl.DOUBLE_MAT3x4:     {ScalarType: Double, Columns: 3, Rows: 4},
+	gl.DOUBLE_MAT4x2:     {ScalarType: Double, Columns: 4, Rows: 2},
+	gl.DOUBLE_MAT4x3:     {ScalarType: Double, Columns: 4, Rows: 3},
+	gl.DOUBLE_MAT4:       {ScalarType: Double, Columns: 4, Rows: 4},
+}
+
+var kGLSamplerTypes = map[uint32]bool{
+	gl.SAMPLER_1D:                    true,
+	gl.SAMPLER_2D:                    true,
+	gl.SAMPLER_3D:                    true,
+	gl.SAMPLER_CUBE:                  true,
+	gl.SAMPLER_2D_RECT:               true,
+	gl.SAMPLER_1D_ARRAY:              true,
+	gl.SAMPLER_2D_ARRAY:              true,
+	gl.SAMPLER_2D_SHADOW:             true,
+	gl.SAMPLER_2D_MULTISAMPLE:        true,
+	gl.SAMPLER_BUFFER:                true,
+	gl.INT_SAMPLER_2D:                true,
+	gl.UNSIGNED_INT_SAMPLER_2D:       true,
+	gl.INT_SAMPLER_2D_ARRAY:          true,
+	gl.UNSIGNED_INT_SAMPLER_2D_ARRAY: true,
+}
+
+// reflectUniforms lists the active uniforms of a linked program that can be set with glUniform,
+// by name. Arrays are listed under their name without the [0] GL reports.
+func reflectUniforms(program uint32) map[string]*ShaderUniform {
+	var count, maxNameLength int32
+	gl.GetProgramiv(program, gl.ACTIVE_UNIFORMS, &count)
+	gl.GetProgramiv(program, gl.ACTIVE_UNIFORM_MAX_LENGTH, &maxNameLength)
+
+	uniforms := make(map[string]*ShaderUniform)
+	nameBuffer := make([]uint8, maxNameLength+1)
+	for i := int32(0); i < count; i++ {
+		var nameLength, size int32
+		var glType uint32
+		gl.GetActiveUniform(program, uint32(i), int32(len(nameBuffer)), &nameLength, &size, &glType, &nameBuffer[0])
+		name := string(nameBuffer[:nameLength])
+		if strings.HasPrefix(name, "gl_") {
+			continue
+		}
+
+		location := gl.GetUniformLocation(program, gl.Str(name+"\x00"))
+		if location == kGLLocationNotFound {
+			// members of uniform blocks are set through buffers
+			continue
+		}
+
+		uniformType := kGLUniformTypes[glType]
+		if strings.HasSuffix(name, "[0]") {
+			name = strings.TrimSuffix(name, "[0]")
+			uniformType.IsArray = true
+		}
+		uniforms[name] = &ShaderUniform{
+			Name:     name,
+			Type:     uniformType,
+			GLType:   glType,
+			Sampler:  kGLSamplerTypes[glType],
+			Size:     int(size),
+			Location: location,
+		}
+	}
+	return uniforms
+}
+
+// checkUniforms checks the stage's definition uniforms against the program's active uniforms,
+// logging the ones that aren't used and the active ones that nothing sets.
+func (stage *FilterStage) checkUniforms(uniformDefinitions []UniformDefinition) error {
+	for _, uniformDefinition := range uniformDefinitions {
+		name := uniformDefinition.Name
+		shaderUniform, exists := stage.activeUniforms[name]
+		if !exists {
+			log.Printf("uniform %s is set but the shader doesn't use it\n", name)
+			continue
+		}
+		shaderUniform.Value = stage.uniforms[name].Value
+		if err := shaderUniform.check(uniformDefinition, shaderUniform.Value); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range sortedUniformNames(stage.activeUniforms) {
+		shaderUniform := stage.activeUniforms[name]
+		_, isSet := stage.uniforms[name]
+		if !isSet && !shaderUniform.Sampler && shaderUniform.Type.ScalarType != 0 && !stage.isEngineUniform(name) {
+			log.Printf("uniform %s %s isn't set and keeps its default value\n", shaderUniform.typeName(), name)
+		}
+	}
+	return nil
+}
+
+// check checks a uniform definition whose value normalizes to normed against the shader's
+// declaration.
+func (shaderUniform *ShaderUniform) check(uniformDefinition UniformDefinition, normed interface{}) error {
+	uniformErr := func(format string, args ...interface{}) error {
+		return &UniformError{Stage: -1, Name: uniformDefinition.Name, Type: uniformDefinition.Type, Value: uniformDefinition.Value, Reason: fmt.Sprintf(format, args...)}
+	}
+	if shaderUniform.Sampler {
+		return uniformErr("the shader declares a sampler, bind it with a texture or input instead")
+	}
+	elementType := uniformDefinition.Type
+	elementType.IsArray = false
+	shaderElementType := shaderUniform.Type
+	shaderElementType.IsArray = false
+	if elementType != shaderElementType {
+		return uniformErr("the shader declares %s", shaderUniform.typeName())
+	}
+	if length := uniformArrayLength(uniformDefinition.Type, normed); length > shaderUniform.Size {
+		return uniformErr("the shader declares %d elements, got %d", shaderUniform.Size, length)
+	}
+	return nil
+}
+
+// Uniforms returns the active uniforms of the stage's program, sorted by name, with the values set
+// by its definition.
+func (stage *FilterStage) Uniforms() []ShaderUniform {
+	uniforms := make([]ShaderUniform, 0, len(stage.activeUniforms))
+	for _, name := range sortedUniformNames(stage.activeUniforms) {
+		uniforms = append(uniforms, *stage.activeUniforms[name])
+	}
+	return uniforms
+}
+
+func (shaderUniform *ShaderUniform) typeName() string {
+	switch {
+	case shaderUniform.Sampler:
+		return "sampler"
+	case shaderUniform.Type.ScalarType == 0:
+		return fmt.Sprintf("GL type 0x%x", shaderUniform.GLType)
+	case shaderUniform.Type.IsArray:
+		return fmt.Sprintf("%s[%d]", glslTypeName(shaderUniform.Type), shaderUniform.Size)
+	}
+	return glslTypeName(shaderUniform.Type)
+}
+
+func sortedUniformNames(uniforms map[string]*ShaderUniform) []string {
+	names := make([]string, 0, len(uniforms))
+	for name := range uniforms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -3,6 +3,8 @@ package glslfilter
 import (
 	"fmt"
 	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
 type ScalarTypeName uint
@@ -12,25 +14,105 @@ const (
 	Float
 	Int
 	Uint
+	Bool
+	// Double needs OpenGL 4.0 or GL_ARB_gpu_shader_fp64.
+	Double
 )
 
 type UniformType struct {
 	ScalarType ScalarTypeName
 	VectorSize int
-	IsArray    bool
+	// Columns and Rows are set for matrices, which only have Float or Double components.
+	Columns int
+	Rows    int
+	IsArray bool
 }
 
-func (uniformType *UniformType) UnmarshalYAML(unmarshal func(interface{}) error) (err error) {
+// components returns the number of scalars in one element of the type.
+func (uniformType UniformType) components() int {
+	switch {
+	case uniformType.Columns > 0:
+		return uniformType.Columns * uniformType.Rows
+	case uniformType.VectorSize > 0:
+		return uniformType.VectorSize
+	}
+	return 1
+}
+
+// String returns the type as it's written in a definition.
+func (uniformType UniformType) String() string {
+	var name string
+	switch uniformType.ScalarType {
+	case Float:
+		name = "float"
+	case Int:
+		name = "int"
+	case Uint:
+		name = "uint"
+	case Bool:
+		name = "bool"
+	case Double:
+		name = "double"
+	default:
+		return "invalid"
+	}
+	switch {
+	case uniformType.Columns > 0:
+		if uniformType.ScalarType == Float {
+			name = "mat"
+		} else {
+			name += "Mat"
+		}
+		name += fmt.Sprintf("%d", uniformType.Columns)
+		if uniformType.Rows != uniformType.Columns {
+			name += fmt.Sprintf("x%d", uniformType.Rows)
+		}
+	case uniformType.VectorSize > 0:
+		name += fmt.Sprintf("Vec%d", uniformType.VectorSize)
+	}
+	if uniformType.IsArray {
+		name = "[]" + name
+	}
+	return name
+}
+
+func (uniformType *UniformType) UnmarshalYAML(value *yaml.Node) (err error) {
+	var rawString string
+	if err = value.Decode(&rawString); err != nil {
+		return err
+	}
+
+	if *uniformType, err = ParseUniformType(rawString); err != nil {
+		return &DefinitionError{Line: value.Line, Column: value.Column, Err: err}
+	}
+	return nil
+}
+
+// kGLSLTypeAliases maps the prefixes of GLSL type names to the names used in definitions.
+var kGLSLTypeAliases = []struct{ glsl, name string }{
+	{"ivec", "intvec"},
+	{"uvec", "uintvec"},
+	{"bvec", "boolvec"},
+	{"dvec", "doublevec"},
+	{"dmat", "doublemat"},
+	{"vec", "floatvec"},
+	{"mat", "floatmat"},
+}
+
+// ParseUniformType parses a uniform type as it's written in a definition, e.g. "float", "intVec2",
+// "[]FloatVec3", "mat3", "mat2x4" or "doubleMat4". The GLSL names, e.g. "bvec2" or "dmat3", are
+// accepted as well. Matrices are named by their columns, then rows.
+func ParseUniformType(rawString string) (uniformType UniformType, err error) {
 	const kArrayPrefix = "[]"
 	const kFloatPrefix = "float"
 	const kIntPrefix = "int"
 	const kUintPrefix = "uint"
+	const kBoolPrefix = "bool"
+	const kDoublePrefix = "double"
 	const kVecPrefix = "vec"
+	const kMatPrefix = "mat"
 
-	var rawString string
-	if err = unmarshal(&rawString); err != nil {
-		return err
-	}
+	typeName := rawString
 	rawString = strings.ToLower(rawString)
 
 	if strings.HasPrefix(rawString, kArrayPrefix) {
@@ -38,6 +120,13 @@ func (uniformType *UniformType) UnmarshalYAML(unmarshal func(interface{}) error)
 		rawString = rawString[len(kArrayPrefix):]
 	}
 
+	for _, alias := range kGLSLTypeAliases {
+		if strings.HasPrefix(rawString, alias.glsl) {
+			rawString = alias.name + rawString[len(alias.glsl):]
+			break
+		}
+	}
+
 	if strings.HasPrefix(rawString, kFloatPrefix) {
 		uniformType.ScalarType = Float
 		rawString = rawString[len(kFloatPrefix):]
@@ -47,8 +136,35 @@ func (uniformType *UniformType) UnmarshalYAML(unmarshal func(interface{}) error)
 	} else if strings.HasPrefix(rawString, kUintPrefix) {
 		uniformType.ScalarType = Uint
 		rawString = rawString[len(kUintPrefix):]
+	} else if strings.HasPrefix(rawString, kBoolPrefix) {
+		uniformType.ScalarType = Bool
+		rawString = rawString[len(kBoolPrefix):]
+	} else if strings.HasPrefix(rawString, kDoublePrefix) {
+		uniformType.ScalarType = Double
+		rawString = rawString[len(kDoublePrefix):]
 	} else {
-		return fmt.Errorf("invalid scalar type specified: \"%s\", options are (float|int|uint)", rawString)
+		return uniformType, fmt.Errorf("invalid scalar type specified: \"%s\", options are (float|int|uint|bool|double|mat)", rawString)
+	}
+
+	if strings.HasPrefix(rawString, kMatPrefix) {
+		if uniformType.ScalarType != Float && uniformType.ScalarType != Double {
+			return uniformType, fmt.Errorf("matrices must be float or double")
+		}
+		rawString = rawString[len(kMatPrefix):]
+		switch len(rawString) {
+		case 1:
+			uniformType.Columns = int(rawString[0] - '0')
+			uniformType.Rows = uniformType.Columns
+		case 3:
+			if rawString[1] == 'x' {
+				uniformType.Columns = int(rawString[0] - '0')
+				uniformType.Rows = int(rawString[2] - '0')
+			}
+		}
+		if uniformType.Columns < 2 || uniformType.Columns > 4 || uniformType.Rows < 2 || uniformType.Rows > 4 {
+			return uniformType, fmt.Errorf("invalid matrix size specified, options are 2,3,4 or NxM with N and M in 2,3,4")
+		}
+		return uniformType, nil
 	}
 
 	if strings.HasPrefix(rawString, kVecPrefix) {
@@ -61,9 +177,11 @@ func (uniformType *UniformType) UnmarshalYAML(unmarshal func(interface{}) error)
 		case "4":
 			uniformType.VectorSize = 4
 		default:
-			return fmt.Errorf("invalid vector size specified, options are 2,3,4")
+			return uniformType, fmt.Errorf("invalid vector size specified, options are 2,3,4")
 		}
+	} else if rawString != "" {
+		return uniformType, fmt.Errorf("invalid type specified: \"%s\", unexpected \"%s\" after the scalar type", typeName, rawString)
 	}
 
-	return nil
+	return uniformType, nil
 }
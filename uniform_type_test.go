@@ -0,0 +1,57 @@
+package glslfilter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseUniformType(t *testing.T) {
+	tests := []struct {
+		name string
+		typ  UniformType
+	}{
+		{"float", UniformType{ScalarType: Float}},
+		{"Int", UniformType{ScalarType: Int}},
+		{"uint", UniformType{ScalarType: Uint}},
+		{"[]bool", UniformType{ScalarType: Bool, IsArray: true}},
+		{"intVec2", UniformType{ScalarType: Int, VectorSize: 2}},
+		{"[]FloatVec3", UniformType{ScalarType: Float, VectorSize: 3, IsArray: true}},
+		{"uvec4", UniformType{ScalarType: Uint, VectorSize: 4}},
+		{"mat3", UniformType{ScalarType: Float, Columns: 3, Rows: 3}},
+		{"mat2x4", UniformType{ScalarType: Float, Columns: 2, Rows: 4}},
+		{"doubleMat4", UniformType{ScalarType: Double, Columns: 4, Rows: 4}},
+		{"dmat3x2", UniformType{ScalarType: Double, Columns: 3, Rows: 2}},
+	}
+	for _, test := range tests {
+		typ, err := ParseUniformType(test.name)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if typ != test.typ {
+			t.Errorf("%s: got %+v, want %+v", test.name, typ, test.typ)
+		}
+	}
+}
+
+func TestParseUniformTypeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  string
+	}{
+		{"float3", `invalid type specified: "float3", unexpected "3" after the scalar type`},
+		{"floatx", `invalid type specified: "floatx", unexpected "x" after the scalar type`},
+		{"intFoo", `invalid type specified: "intFoo", unexpected "foo" after the scalar type`},
+		{"[]boolean", `invalid type specified: "[]boolean", unexpected "ean" after the scalar type`},
+		{"vec5", "invalid vector size specified"},
+		{"intVec", "invalid vector size specified"},
+		{"intMat2", "matrices must be float or double"},
+		{"mat5", "invalid matrix size specified"},
+		{"mat2x", "invalid matrix size specified"},
+		{"half", `invalid scalar type specified: "half"`},
+	}
+	for _, test := range tests {
+		_, err := ParseUniformType(test.name)
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, want %s", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package glslfilter
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValidateDefinition checks a definition without creating any GL objects: that it parses, that the
+// shader and texture files it refers to exist, that uniform values match their types, that names
+// aren't bound or defined twice and that stage inputs refer to existing outputs. Every problem
+// found is returned, positioned at the part of the definition responsible where possible.
+func ValidateDefinition(definitionString []byte, options LoadOptions) (problems []*DefinitionError) {
+	root, params, err := parseDefinition(definitionString, options)
+	if err != nil {
+		var definitionErr *DefinitionError
+		if errors.As(err, &definitionErr) {
+			return []*DefinitionError{definitionErr}
+		}
+		return []*DefinitionError{{Err: err}}
+	}
+
+	validator := definitionValidator{files: params.files}
+	validator.validate(root)
+	sort.SliceStable(validator.problems, func(i, j int) bool {
+		a, b := validator.problems[i], validator.problems[j]
+		return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+	})
+	return validator.problems
+}
+
+type definitionValidator struct {
+	files        definitionFiles
+	includePaths []string
+	problems     []*DefinitionError
+}
+
+func (validator *definitionValidator) report(node *yaml.Node, format string, args ...interface{}) {
+	validator.problems = append(validator.problems, newDefinitionError(node, format, args...))
+}
+
+// decode decodes node into out, reporting errors that don't carry a position at node.
+func (validator *definitionValidator) decode(node *yaml.Node, out interface{}) bool {
+	err := node.Decode(out)
+	if err == nil {
+		return true
+	}
+
+	var typeErr *yaml.TypeError
+	var definitionErr *DefinitionError
+	switch {
+	case errors.As(err, &typeErr):
+		for _, message := range typeErr.Errors {
+			validator.problems = append(validator.problems, newYAMLDefinitionError(errors.New(message)))
+		}
+	case errors.As(err, &definitionErr):
+		validator.problems = append(validator.problems, definitionErr)
+	default:
+		validator.problems = append(validator.problems, &DefinitionError{Line: node.Line, Column: node.Column, Err: err})
+	}
+	return false
+}
+
+// decodeFields decodes the keys of a mapping node into out one at a time, so that a bad value
+// doesn't hide problems with the others, and returns the keys that failed. Nothing is decoded
+// when node isn't a mapping.
+func (validator *definitionValidator) decodeFields(node *yaml.Node, out interface{}) (failed map[string]bool, decoded bool) {
+	if node.Kind != yaml.MappingNode {
+		validator.decode(node, out)
+		return nil, false
+	}
+	failed = make(map[string]bool)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		field := *node
+		field.Content = node.Content[i : i+2]
+		if !validator.decode(&field, out) {
+			failed[node.Content[i].Value] = true
+		}
+	}
+	return failed, true
+}
+
+func (validator *definitionValidator) checkFile(node *yaml.Node, stageIndex int, path string) (exists bool) {
+	info, err := validator.files.stat(validator.files.resolve(path))
+	if err != nil {
+		validator.report(node, "stage %d: %v", stageIndex, err)
+	} else if info.IsDir() {
+		validator.report(node, "stage %d: %s is a directory", stageIndex, path)
+	}
+	return err == nil && !info.IsDir()
+}
+
+func (validator *definitionValidator) validate(root *yaml.Node) {
+	var definition Definition
+
+	if outputNode := mappingValue(root, "output"); outputNode != nil && validator.decode(outputNode, &definition.Output) {
+		if err := definition.Output.Validate(); err != nil {
+			validator.report(outputNode, "output: %v", err)
+		}
+	}
+
+	if includePathsNode := mappingValue(root, "includePaths"); includePathsNode != nil && validator.decode(includePathsNode, &definition.IncludePaths) {
+		for i, includePath := range definition.IncludePaths {
+			includePath = validator.files.resolve(includePath)
+			if info, err := validator.files.stat(includePath); err != nil {
+				validator.report(includePathsNode.Content[i], "include path: %v", err)
+			} else if !info.IsDir() {
+				validator.report(includePathsNode.Content[i], "include path: %s is not a directory", includePath)
+			}
+			validator.includePaths = append(validator.includePaths, includePath)
+		}
+	}
+
+	stagesNode := mappingValue(root, "stages")
+	if stagesNode == nil || stagesNode.Kind != yaml.SequenceNode || len(stagesNode.Content) == 0 {
+		validator.report(firstNode(stagesNode, root), "no stages defined")
+		return
+	}
+
+	// lists are decoded item by item in validateStage so one bad value doesn't hide the others
+	stages := make([]StageDefinition, len(stagesNode.Content))
+	decoded := make([]bool, len(stages))
+	outputs := make(map[string]int)
+	for i, stageNode := range stagesNode.Content {
+		if decoded[i] = validator.decode(withoutKeys(stageNode, "textures", "inputs", "uniforms"), &stages[i]); !decoded[i] {
+			continue
+		}
+		output := stages[i].Output
+		if output == "" {
+			output = fmt.Sprintf("stage%d", i)
+		}
+		if producer, exists := outputs[output]; exists {
+			validator.report(firstNode(mappingValue(stageNode, "output"), stageNode), "stage %d: output %q is already defined by stage %d", i, output, producer)
+			continue
+		}
+		outputs[output] = i
+	}
+
+	textureNames := make(map[string]bool)
+	for i, stageNode := range stagesNode.Content {
+		validator.validateStage(i, stageNode, stages[i], decoded[i], outputs, textureNames)
+	}
+
+	renderNode := mappingValue(root, "render")
+	if renderNode == nil {
+		validator.report(root, "render width and height or size.fromTexture must be set")
+	} else if validator.decode(renderNode, &definition.Render) {
+		source := definition.Render.Size.FromTexture
+		if source == "" && (definition.Render.Width <= 0 || definition.Render.Height <= 0) {
+			validator.report(renderNode, "render width and height or size.fromTexture must be set")
+		} else if source != "" && !textureNames[source] {
+			sourceNode := mappingValue(mappingValue(renderNode, "size"), "fromTexture")
+			validator.report(firstNode(sourceNode, renderNode), "render size: no texture named %s", source)
+		}
+	}
+}
+
+func (validator *definitionValidator) validateStage(i int, stageNode *yaml.Node, stageDefinition StageDefinition, decoded bool, outputs map[string]int, textureNames map[string]bool) {
+	if decoded {
+		validator.validateShader(i, stageNode, stageDefinition)
+		if key, err := stageDefinition.Size.check(); err != nil {
+			validator.report(firstNode(mappingValue(stageNode, key), stageNode), "stage %d: %v", i, err)
+		}
+	}
+
+	// textures and inputs are both bound to sampler uniforms
+	bindingNames := make(map[string]bool)
+	checkBindingName := func(node *yaml.Node, bindingName string) {
+		if bindingName == "" {
+			validator.report(node, "stage %d: missing name", i)
+		} else if bindingNames[bindingName] {
+			validator.report(firstNode(mappingValue(node, "name"), node), "stage %d: %q is bound more than once", i, bindingName)
+		}
+		bindingNames[bindingName] = true
+	}
+
+	for _, textureNode := range validator.listItems(stageNode, "textures", i) {
+		var texture TextureDefinition
+		failed, decoded := validator.decodeFields(textureNode, &texture)
+		if !decoded {
+			continue
+		}
+		if !failed["name"] {
+			checkBindingName(textureNode, texture.Name)
+			textureNames[texture.Name] = true
+		}
+		switch {
+		case failed["path"]:
+		case texture.Path == "":
+			validator.report(textureNode, "stage %d: texture %s has no path", i, texture.Name)
+		default:
+			validator.checkFile(firstNode(mappingValue(textureNode, "path"), textureNode), i, texture.Path)
+		}
+	}
+
+	for _, inputNode := range validator.listItems(stageNode, "inputs", i) {
+		var input StageInput
+		failed, decoded := validator.decodeFields(inputNode, &input)
+		if !decoded {
+			continue
+		}
+		if !failed["name"] {
+			checkBindingName(inputNode, input.BindingName)
+		}
+		if failed["target"] {
+			continue
+		}
+		targetNode := firstNode(mappingValue(inputNode, "target"), inputNode)
+		if producer, exists := outputs[input.Target]; !exists {
+			validator.report(targetNode, "stage %d: input %q references unknown output %q", i, input.BindingName, input.Target)
+		} else if producer == i {
+			validator.report(targetNode, "stage %d: input %q references its own output", i, input.BindingName)
+		}
+	}
+
+	uniformNames := make(map[string]bool)
+	for _, uniformNode := range validator.listItems(stageNode, "uniforms", i) {
+		var uniformDefinition UniformDefinition
+		failed, decoded := validator.decodeFields(uniformNode, &uniformDefinition)
+		if !decoded {
+			continue
+		}
+		if !failed["name"] {
+			if uniformNames[uniformDefinition.Name] {
+				validator.report(firstNode(mappingValue(uniformNode, "name"), uniformNode), "stage %d: uniform %s is defined more than once", i, uniformDefinition.Name)
+			}
+			uniformNames[uniformDefinition.Name] = true
+		}
+
+		if failed["type"] || failed["value"] || failed["rowMajor"] {
+			continue
+		}
+		if _, err := normalizeUniformValue(uniformDefinition); err != nil {
+			uniformErr := err.(*UniformError)
+			uniformErr.Stage = i
+			valueNode := firstNode(mappingValue(uniformNode, "value"), uniformNode)
+			validator.problems = append(validator.problems, &DefinitionError{Line: valueNode.Line, Column: valueNode.Column, Err: uniformErr})
+		}
+	}
+}
+
+// validateShader checks that a stage's shader exists and that its includes and defines can be
+// expanded.
+func (validator *definitionValidator) validateShader(i int, stageNode *yaml.Node, stageDefinition StageDefinition) {
+	source := ShaderSource{Text: stageDefinition.FragmentShaderSource}
+	sourceNode := firstNode(mappingValue(stageNode, "fragmentShaderSource"), stageNode)
+	if source.Text == "" {
+		if stageDefinition.FragmentShaderPath == "" {
+			validator.report(stageNode, "stage %d: fragmentShaderPath or fragmentShaderSource must be set", i)
+			return
+		}
+		sourceNode = firstNode(mappingValue(stageNode, "fragmentShaderPath"), stageNode)
+		if !validator.checkFile(sourceNode, i, stageDefinition.FragmentShaderPath) {
+			return
+		}
+
+		var err error
+		source.File = validator.files.resolve(stageDefinition.FragmentShaderPath)
+		if source.Text, err = validator.files.loadFragmentShader(source.File); err != nil {
+			validator.report(sourceNode, "stage %d: %v", i, err)
+			return
+		}
+	}
+
+	if _, err := preprocessShader(source, validator.files, validator.includePaths, stageDefinition.Defines); err != nil {
+		var shaderErr *ShaderError
+		if !errors.As(err, &shaderErr) {
+			sourceNode = firstNode(mappingValue(stageNode, "defines"), stageNode)
+		}
+		validator.report(sourceNode, "stage %d: %v", i, err)
+	}
+}
+
+// listItems returns the items of the list under key in a stage, reporting a value that isn't a
+// list.
+func (validator *definitionValidator) listItems(stageNode *yaml.Node, key string, stageIndex int) []*yaml.Node {
+	node := mappingValue(stageNode, key)
+	if node == nil || node.ShortTag() == "!!null" {
+		return nil
+	}
+	if node.Kind != yaml.SequenceNode {
+		validator.report(node, "stage %d: %s must be a list", stageIndex, key)
+		return nil
+	}
+	return node.Content
+}
+
+// withoutKeys returns a copy of a mapping node without the given keys.
+func withoutKeys(node *yaml.Node, keys ...string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return node
+	}
+	result := *node
+	result.Content = nil
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		skip := false
+		for _, key := range keys {
+			skip = skip || node.Content[i].Value == key
+		}
+		if !skip {
+			result.Content = append(result.Content, node.Content[i], node.Content[i+1])
+		}
+	}
+	return &result
+}
+
+func firstNode(nodes ...*yaml.Node) *yaml.Node {
+	for _, node := range nodes {
+		if node != nil {
+			return node
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package glslfilter
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestValidateDefinitionReportsEveryFieldProblem(t *testing.T) {
+	definition := `render:
+  width: 8
+  height: 8
+stages:
+  - fragmentShaderSource: |
+      #version 330
+      void main() {}
+    textures:
+      - name: a
+        path: missing.png
+        filter: blurry
+      - name: a
+        path: present.png
+    uniforms:
+      - name: u
+        type: float
+        value: 1
+      - name: u
+        type: floot
+        value: 2
+      - name: w
+        type: float3
+        value: [1, 2, 3]
+`
+	fsys := fstest.MapFS{"present.png": &fstest.MapFile{}}
+	problems := ValidateDefinition([]byte(definition), LoadOptions{FS: fsys})
+
+	want := []struct {
+		line    int
+		message string
+	}{
+		{10, "missing.png"},
+		{11, `invalid texture filter specified: "blurry"`},
+		{12, `"a" is bound more than once`},
+		{18, "uniform u is defined more than once"},
+		{19, `invalid scalar type specified: "floot"`},
+		{22, `invalid type specified: "float3"`},
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("got %d problems, want %d: %v", len(problems), len(want), problems)
+	}
+	for i, problem := range problems {
+		if problem.Line != want[i].line || !strings.Contains(problem.Error(), want[i].message) {
+			t.Errorf("problem %d: got %q, want %q at line %d", i, problem, want[i].message, want[i].line)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package glslfilter
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"runtime"
+	"sync"
+)
+
+var ErrWorkerClosed = errors.New("worker is closed")
+
+// Job is run by a Worker on its GL thread. The engine must not be used after the job returns.
+type Job func(engine *Engine) (interface{}, error)
+
+type Result struct {
+	Value interface{}
+	Err   error
+}
+
+type workerJob struct {
+	job    Job
+	result chan<- Result
+}
+
+// Worker owns an Engine on a goroutine locked to its own OS thread, so that it can be used from any
+// goroutine. Jobs run one at a time in the order they're submitted.
+type Worker struct {
+	mutex sync.Mutex
+	// queued is signalled when a job is queued or the worker is closed
+	queued  *sync.Cond
+	queue   []workerJob
+	closed  bool
+	stopped chan struct{}
+}
+
+// NewWorker starts the worker goroutine and creates the engine and context on it. Closing the worker
+// closes the engine and destroys the context. Providers that must run on the main thread, such as
+// GLFW windows, can't be used with a worker.
+func NewWorker(context ContextProvider, viewportDimensions image.Rectangle, debug bool) (*Worker, error) {
+	worker := &Worker{stopped: make(chan struct{})}
+	worker.queued = sync.NewCond(&worker.mutex)
+
+	started := make(chan error)
+	go worker.run(context, viewportDimensions, debug, started)
+	if err := <-started; err != nil {
+		return nil, err
+	}
+	return worker, nil
+}
+
+func (worker *Worker) run(context ContextProvider, viewportDimensions image.Rectangle, debug bool, started chan<- error) {
+	defer close(worker.stopped)
+	runtime.LockOSThread()
+
+	engine, err := NewEngineWithContext(context, viewportDimensions, debug)
+	started <- err
+	if err != nil {
+		return
+	}
+	defer engine.Close()
+
+	for {
+		job, ok := worker.next()
+		if !ok {
+			return
+		}
+		job.result <- runJob(job.job, engine)
+	}
+}
+
+// next waits for the next queued job. Once the worker is closed it fails the jobs still queued
+// and returns false.
+func (worker *Worker) next() (job workerJob, ok bool) {
+	worker.mutex.Lock()
+	defer worker.mutex.Unlock()
+
+	for len(worker.queue) == 0 && !worker.closed {
+		worker.queued.Wait()
+	}
+	if worker.closed {
+		for _, job := range worker.queue {
+			job.result <- Result{Err: ErrWorkerClosed}
+		}
+		worker.queue = nil
+		return job, false
+	}
+
+	job = worker.queue[0]
+	worker.queue[0] = workerJob{}
+	worker.queue = worker.queue[1:]
+	return job, true
+}
+
+// runJob turns a panicking job into an error so the worker keeps serving other jobs.
+func runJob(job Job, engine *Engine) (result Result) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			result = Result{Err: fmt.Errorf("job panicked: %v", recovered)}
+		}
+	}()
+	value, err := job(engine)
+	return Result{Value: value, Err: err}
+}
+
+// Submit queues job and returns right away with a channel that receives its result. It is safe to
+// call from any goroutine. The queue has no limit. Jobs submitted after Close, or still queued
+// when it's called, fail with ErrWorkerClosed.
+func (worker *Worker) Submit(job Job) <-chan Result {
+	result := make(chan Result, 1)
+
+	worker.mutex.Lock()
+	defer worker.mutex.Unlock()
+	if worker.closed {
+		result <- Result{Err: ErrWorkerClosed}
+		return result
+	}
+	worker.queue = append(worker.queue, workerJob{job, result})
+	worker.queued.Signal()
+	return result
+}
+
+// Close waits for the running job to finish, then stops the worker and closes its engine.
+func (worker *Worker) Close() {
+	worker.mutex.Lock()
+	worker.closed = true
+	worker.queued.Broadcast()
+	worker.mutex.Unlock()
+	<-worker.stopped
+}